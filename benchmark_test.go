@@ -0,0 +1,104 @@
+package gomian
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// benchmarkProcs lists the GOMAXPROCS values the parallel benchmarks run at.
+var benchmarkProcs = []int{1, 2, 4, 8, 16}
+
+// runParallelAtProcs runs body as a parallel sub-benchmark at each GOMAXPROCS value.
+func runParallelAtProcs(b *testing.B, body func(pb *testing.PB)) {
+	for _, procs := range benchmarkProcs {
+		b.Run(fmt.Sprintf("procs=%d", procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+			b.ReportAllocs()
+			b.RunParallel(body)
+		})
+	}
+}
+
+func BenchmarkExecuteClosedConsecutive(b *testing.B) {
+	cb := NewCircuitBreaker(Settings{
+		Name:             "BenchBreaker",
+		FailureThreshold: ConsecutiveFailures(1 << 62),
+		SuccessThreshold: 1,
+		Timeout:          time.Minute,
+	})
+	defer cb.Close()
+
+	op := func() error { return nil }
+	runParallelAtProcs(b, func(pb *testing.PB) {
+		for pb.Next() {
+			cb.Execute(op)
+		}
+	})
+}
+
+func BenchmarkExecuteClosedFailureRate(b *testing.B) {
+	cb := NewCircuitBreaker(Settings{
+		Name:                 "BenchBreaker",
+		FailureThreshold:     NewFailureRateThreshold(1, 0),
+		SuccessThreshold:     1,
+		Timeout:              time.Minute,
+		RollingWindow:        10 * time.Second,
+		MinimumRequestVolume: 1 << 62,
+	})
+	defer cb.Close()
+
+	op := func() error { return nil }
+	runParallelAtProcs(b, func(pb *testing.PB) {
+		for pb.Next() {
+			cb.Execute(op)
+		}
+	})
+}
+
+func BenchmarkExecuteClosedMixed(b *testing.B) {
+	cb := NewCircuitBreaker(Settings{
+		Name:                 "BenchBreaker",
+		FailureThreshold:     NewFailureRateThreshold(1, 0),
+		SuccessThreshold:     1,
+		Timeout:              time.Minute,
+		RollingWindow:        10 * time.Second,
+		MinimumRequestVolume: 1 << 62,
+	})
+	defer cb.Close()
+
+	errFailure := errors.New("failure")
+	runParallelAtProcs(b, func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			i++
+			cb.Execute(func() error {
+				if i%10 == 0 {
+					return errFailure
+				}
+				return nil
+			})
+		}
+	})
+}
+
+func BenchmarkExecuteOpen(b *testing.B) {
+	cb := NewCircuitBreaker(Settings{
+		Name:             "BenchBreaker",
+		FailureThreshold: ConsecutiveFailures(1),
+		SuccessThreshold: 1,
+		Timeout:          time.Hour,
+	})
+	defer cb.Close()
+
+	cb.Execute(func() error { return errors.New("failure") })
+
+	op := func() error { return nil }
+	runParallelAtProcs(b, func(pb *testing.PB) {
+		for pb.Next() {
+			cb.Execute(op)
+		}
+	})
+}
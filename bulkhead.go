@@ -0,0 +1,195 @@
+package gomian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nutcase/gomian/internal/clock"
+)
+
+// BulkheadSettings defines the configuration for a Bulkhead.
+type BulkheadSettings struct {
+	// Name is a unique identifier for this bulkhead.
+	Name string
+
+	// MaxConcurrent is the maximum number of calls running at once.
+	MaxConcurrent int
+
+	// MaxQueue is the maximum number of calls waiting for a slot once
+	// MaxConcurrent calls are running. If zero, such calls are rejected right away.
+	MaxQueue int
+
+	// QueueTimeout is how long a call waits in the queue before it is
+	// rejected. If zero, it waits until its context is done.
+	QueueTimeout time.Duration
+
+	// Clock is the source of time for the queue timeout.
+	// If nil, the real clock is used.
+	Clock Clock
+}
+
+// DefaultBulkheadSettings returns a BulkheadSettings struct with sensible default values.
+func DefaultBulkheadSettings() BulkheadSettings {
+	return BulkheadSettings{
+		Name:          "default",
+		MaxConcurrent: 10,
+	}
+}
+
+// Validate checks the settings for inconsistent or out-of-range values.
+// All problems are reported together, each wrapping ErrInvalidSettings.
+func (s BulkheadSettings) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrInvalidSettings, fmt.Sprintf(format, args...)))
+	}
+
+	if s.Name == "" {
+		invalid("name must not be empty")
+	}
+	if s.MaxConcurrent < 1 {
+		invalid("max concurrent calls must be at least 1, got %d", s.MaxConcurrent)
+	}
+	if s.MaxQueue < 0 {
+		invalid("max queue must not be negative, got %d", s.MaxQueue)
+	}
+	if s.QueueTimeout < 0 {
+		invalid("queue timeout must not be negative, got %v", s.QueueTimeout)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Bulkhead limits the number of concurrent calls to a dependency, so that a
+// slow dependency cannot tie up every goroutine of its callers. Calls beyond
+// MaxConcurrent wait in a bounded queue, and are rejected with ErrBulkheadFull
+// once the queue is full or they waited for QueueTimeout.
+//
+// A Bulkhead can be used on its own or attached to one or more circuit
+// breakers with Settings.Bulkhead.
+type Bulkhead struct {
+	name       string
+	settings   BulkheadSettings
+	clock      clock.Clock
+	slots      chan struct{}
+	queued     atomic.Int64
+	requests   atomic.Uint64
+	rejections atomic.Uint64
+	callbacks  *Callbacks
+}
+
+// NewBulkhead creates a new Bulkhead with the provided settings.
+func NewBulkhead(settings BulkheadSettings) *Bulkhead {
+	defaults := DefaultBulkheadSettings()
+	if settings.Name == "" {
+		settings.Name = defaults.Name
+	}
+	if settings.MaxConcurrent <= 0 {
+		settings.MaxConcurrent = defaults.MaxConcurrent
+	}
+	settings.MaxQueue = max(settings.MaxQueue, 0)
+
+	return &Bulkhead{
+		name:      settings.Name,
+		settings:  settings,
+		clock:     clock.OrReal(settings.Clock),
+		slots:     make(chan struct{}, settings.MaxConcurrent),
+		callbacks: NewCallbacks(),
+	}
+}
+
+// Execute executes the given function once a slot is available, or returns
+// ErrBulkheadFull without executing it.
+func (b *Bulkhead) Execute(op func() error) error {
+	return b.ExecuteContext(context.Background(), func(ctx context.Context) error {
+		return op()
+	})
+}
+
+// ExecuteContext executes the given function with context once a slot is
+// available, or returns ErrBulkheadFull without executing it. If ctx is done
+// while the call is queued, its error is returned instead.
+func (b *Bulkhead) ExecuteContext(ctx context.Context, op func(context.Context) error) error {
+	if err := b.acquire(ctx); err != nil {
+		return err
+	}
+	defer b.release()
+
+	return op(ctx)
+}
+
+// acquire takes a slot, waiting in the queue if there is room for it.
+func (b *Bulkhead) acquire(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	b.requests.Add(1)
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if b.queued.Add(1) > int64(b.settings.MaxQueue) {
+		b.queued.Add(-1)
+		return b.reject()
+	}
+	defer b.queued.Add(-1)
+
+	waitCtx := ctx
+	if b.settings.QueueTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = clock.WithTimeoutCause(ctx, b.clock, b.settings.QueueTimeout, ErrBulkheadFull)
+		defer cancel()
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-waitCtx.Done():
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return b.reject()
+	}
+}
+
+// release frees the slot taken by acquire.
+func (b *Bulkhead) release() {
+	<-b.slots
+}
+
+// reject counts and notifies a rejected call.
+func (b *Bulkhead) reject() error {
+	b.rejections.Add(1)
+	b.callbacks.NotifyRejection(b.name)
+	return ErrBulkheadFull
+}
+
+// OnRejection registers a callback for calls rejected by the bulkhead.
+func (b *Bulkhead) OnRejection(callback RejectionCallback) {
+	b.callbacks.AddOnRejection(callback)
+}
+
+// Name returns the name of the bulkhead.
+func (b *Bulkhead) Name() string {
+	return b.name
+}
+
+// GetMetrics returns the current metrics of the bulkhead. A Bulkhead has no
+// states, so State is always Closed. TotalRequests counts every call
+// attempted, including rejected ones.
+func (b *Bulkhead) GetMetrics() Metrics {
+	return Metrics{
+		Name:               b.name,
+		State:              Closed,
+		TotalRequests:      b.requests.Load(),
+		ActiveCalls:        uint64(len(b.slots)),
+		QueuedCalls:        uint64(max(b.queued.Load(), 0)),
+		BulkheadRejections: b.rejections.Load(),
+	}
+}
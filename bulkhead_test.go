@@ -0,0 +1,277 @@
+package gomian
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nutcase/gomian/clocktest"
+)
+
+// occupy runs n calls through execute that block until the returned function
+// is called, and waits for them to start.
+func occupy(t *testing.T, n int, execute func(func() error) error) (release func()) {
+	t.Helper()
+
+	unblock := make(chan struct{})
+	started := make(chan struct{}, n)
+	done := make(chan struct{}, n)
+	for range n {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			execute(func() error {
+				started <- struct{}{}
+				<-unblock
+				return nil
+			})
+		}()
+	}
+	for range n {
+		<-started
+	}
+
+	return func() {
+		close(unblock)
+		for range n {
+			<-done
+		}
+	}
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBulkheadRejectsWhenFull(t *testing.T) {
+	b := NewBulkhead(BulkheadSettings{Name: "TestBulkhead", MaxConcurrent: 2})
+
+	rejections := 0
+	b.OnRejection(func(name string) {
+		rejections++
+	})
+
+	release := occupy(t, 2, b.Execute)
+
+	if metrics := b.GetMetrics(); metrics.ActiveCalls != 2 {
+		t.Errorf("ActiveCalls should be 2, got %d", metrics.ActiveCalls)
+	}
+
+	err := b.Execute(func() error {
+		t.Error("Call should not be executed when the bulkhead is full")
+		return nil
+	})
+	if !errors.Is(err, ErrBulkheadFull) {
+		t.Errorf("Execute should return ErrBulkheadFull, got %v", err)
+	}
+	if rejections != 1 {
+		t.Errorf("Rejection callback should be called once, got %d", rejections)
+	}
+
+	release()
+
+	if err := b.Execute(func() error { return nil }); err != nil {
+		t.Errorf("Execute should succeed once slots are free, got %v", err)
+	}
+
+	metrics := b.GetMetrics()
+	if metrics.TotalRequests != 4 || metrics.BulkheadRejections != 1 || metrics.ActiveCalls != 0 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+}
+
+func TestBulkheadQueue(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	b := NewBulkhead(BulkheadSettings{
+		Name:          "TestBulkhead",
+		MaxConcurrent: 1,
+		MaxQueue:      1,
+		QueueTimeout:  time.Second,
+		Clock:         clk,
+	})
+
+	release := occupy(t, 1, b.Execute)
+
+	// The first waiting call is queued, the second one is rejected
+	queued := make(chan error, 1)
+	go func() {
+		queued <- b.Execute(func() error { return nil })
+	}()
+	waitFor(t, func() bool { return b.GetMetrics().QueuedCalls == 1 })
+
+	if err := b.Execute(func() error { return nil }); !errors.Is(err, ErrBulkheadFull) {
+		t.Errorf("Execute should return ErrBulkheadFull when the queue is full, got %v", err)
+	}
+
+	// The queued call runs once a slot is released
+	release()
+	if err := <-queued; err != nil {
+		t.Errorf("Queued call should succeed once a slot is free, got %v", err)
+	}
+
+	// A queued call is rejected once the queue timeout expires
+	release = occupy(t, 1, b.Execute)
+	go func() {
+		queued <- b.Execute(func() error {
+			t.Error("Call should not be executed after its queue timeout")
+			return nil
+		})
+	}()
+	waitFor(t, func() bool { return clk.PendingTimers() == 1 })
+	clk.Advance(time.Second)
+
+	if err := <-queued; !errors.Is(err, ErrBulkheadFull) {
+		t.Errorf("Queued call should return ErrBulkheadFull after the queue timeout, got %v", err)
+	}
+	release()
+}
+
+func TestBulkheadQueueCallerCancellation(t *testing.T) {
+	b := NewBulkhead(BulkheadSettings{Name: "TestBulkhead", MaxConcurrent: 1, MaxQueue: 1})
+	release := occupy(t, 1, b.Execute)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := make(chan error, 1)
+	go func() {
+		queued <- b.ExecuteContext(ctx, func(ctx context.Context) error { return nil })
+	}()
+	waitFor(t, func() bool { return b.GetMetrics().QueuedCalls == 1 })
+	cancel()
+
+	if err := <-queued; !errors.Is(err, context.Canceled) {
+		t.Errorf("Queued call should return the context error, got %v", err)
+	}
+	if rejections := b.GetMetrics().BulkheadRejections; rejections != 0 {
+		t.Errorf("Canceled call should not count as a rejection, got %d", rejections)
+	}
+}
+
+func TestBulkheadSettingsValidate(t *testing.T) {
+	if err := DefaultBulkheadSettings().Validate(); err != nil {
+		t.Errorf("Default settings should be valid, got %v", err)
+	}
+
+	err := BulkheadSettings{MaxQueue: -1, QueueTimeout: -time.Second}.Validate()
+	if !errors.Is(err, ErrInvalidSettings) {
+		t.Fatalf("Validate should return ErrInvalidSettings, got %v", err)
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 4 {
+		t.Errorf("Validate should report 4 problems, got %d: %v", n, err)
+	}
+}
+
+func TestCircuitBreakerBulkhead(t *testing.T) {
+	tests := []struct {
+		name           string
+		countAsFailure bool
+		wantState      State
+	}{
+		{"ignored", false, Closed},
+		{"counted as failure", true, Open},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bulkhead := NewBulkhead(BulkheadSettings{Name: "TestBulkhead", MaxConcurrent: 1})
+			cb, err := New("TestBreaker",
+				WithConsecutiveFailures(1),
+				WithBulkhead(bulkhead, tt.countAsFailure),
+			)
+			if err != nil {
+				t.Fatalf("New should succeed, got %v", err)
+			}
+			defer cb.Close()
+
+			var outcomes []string
+			cb.OnOutcome(func(name string, outcome Outcome, err error) {
+				outcomes = append(outcomes, outcome.Name)
+			})
+
+			release := occupy(t, 1, cb.Execute)
+			if active := cb.GetMetrics().ActiveCalls; active != 1 {
+				t.Errorf("ActiveCalls should be 1, got %d", active)
+			}
+
+			err = cb.Execute(func() error {
+				t.Error("Call should not be executed when the bulkhead is full")
+				return nil
+			})
+			if !errors.Is(err, ErrBulkheadFull) || IsCircuitOpen(err) {
+				t.Errorf("Execute should return ErrBulkheadFull, got %v", err)
+			}
+			release()
+
+			metrics := cb.GetMetrics()
+			if metrics.BulkheadRejections != 1 || metrics.Outcomes["BulkheadFull"] != 1 {
+				t.Errorf("Rejection should be counted, got %+v", metrics)
+			}
+			if metrics.State != tt.wantState {
+				t.Errorf("State should be %v, got %v", tt.wantState, metrics.State)
+			}
+			// The blocked call's own outcome is discarded if the rejection tripped the circuit
+			if len(outcomes) == 0 || outcomes[0] != "BulkheadFull" {
+				t.Errorf("Outcome callback should receive BulkheadFull first, got %v", outcomes)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerBulkheadAbandonedCalls(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	bulkhead := NewBulkhead(BulkheadSettings{Name: "TestBulkhead", MaxConcurrent: 1})
+	cb, err := New("TestBreaker",
+		WithConsecutiveFailures(10),
+		WithBulkhead(bulkhead, false),
+		WithCallTimeout(10*time.Millisecond),
+		WithAbandonOnTimeout(),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	// The call is abandoned after its timeout but keeps running
+	release := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- cb.Execute(func() error {
+			<-release
+			return nil
+		})
+	}()
+	waitFor(t, func() bool { return clk.PendingTimers() == 1 })
+	clk.Advance(10 * time.Millisecond)
+	if err := <-errc; !errors.Is(err, ErrCallTimeout) {
+		t.Fatalf("Execute should time out, got %v", err)
+	}
+
+	// The abandoned call still holds its slot
+	if metrics := cb.GetMetrics(); metrics.AbandonedCalls != 1 || metrics.ActiveCalls != 1 {
+		t.Errorf("Abandoned call should keep its bulkhead slot, got %+v", metrics)
+	}
+	err = cb.Execute(func() error {
+		t.Error("Call should not be executed while an abandoned call holds the slot")
+		return nil
+	})
+	if !errors.Is(err, ErrBulkheadFull) {
+		t.Errorf("Execute should return ErrBulkheadFull, got %v", err)
+	}
+
+	// The slot is freed once the abandoned call returns
+	close(release)
+	waitFor(t, func() bool { return cb.GetMetrics().ActiveCalls == 0 })
+	if err := cb.Execute(func() error { return nil }); err != nil {
+		t.Errorf("Execute should succeed once the slot is free, got %v", err)
+	}
+}
@@ -6,6 +6,23 @@ type StateChangeCallback func(name string, from, to State)
 // TripCallback is a function that is called when the circuit breaker trips from Closed to Open.
 type TripCallback func(name string, err error)
 
+// TripEvent describes why a circuit breaker tripped.
+type TripEvent struct {
+	// Name is the name of the circuit breaker.
+	Name string
+	// Err is the error of the call that tripped the circuit, or nil if the
+	// trip followed a successful call, e.g. a slow one.
+	Err error
+	// Threshold is the configured failure threshold.
+	Threshold FailureThresholdType
+	// TrippedBy lists the thresholds that fired. For a composite threshold
+	// these are its sub-thresholds; otherwise it is Threshold itself.
+	TrippedBy []FailureThresholdType
+}
+
+// TripEventCallback is a function that is called with the details of a trip from Closed to Open.
+type TripEventCallback func(event TripEvent)
+
 // ResetCallback is a function that is called when the circuit breaker resets from Open/HalfOpen to Closed.
 type ResetCallback func(name string)
 
@@ -15,6 +32,15 @@ type SuccessCallback func(name string)
 // FailureCallback is a function that is called when a request fails.
 type FailureCallback func(name string, err error)
 
+// IgnoredCallback is a function that is called when a call's outcome is ignored.
+type IgnoredCallback func(name string, err error)
+
+// OutcomeCallback is a function that is called with the outcome of every executed call.
+type OutcomeCallback func(name string, outcome Outcome, err error)
+
+// PanicCallback is a function that is called when an operation panics.
+type PanicCallback func(name string, err *PanicError)
+
 // RejectionCallback is a function that is called when a request is rejected due to the circuit being open.
 type RejectionCallback func(name string)
 
@@ -22,9 +48,13 @@ type RejectionCallback func(name string)
 type Callbacks struct {
 	onStateChange []StateChangeCallback
 	onTrip        []TripCallback
+	onTripEvent   []TripEventCallback
 	onReset       []ResetCallback
 	onSuccess     []SuccessCallback
 	onFailure     []FailureCallback
+	onIgnored     []IgnoredCallback
+	onOutcome     []OutcomeCallback
+	onPanic       []PanicCallback
 	onRejection   []RejectionCallback
 }
 
@@ -33,9 +63,13 @@ func NewCallbacks() *Callbacks {
 	return &Callbacks{
 		onStateChange: make([]StateChangeCallback, 0),
 		onTrip:        make([]TripCallback, 0),
+		onTripEvent:   make([]TripEventCallback, 0),
 		onReset:       make([]ResetCallback, 0),
 		onSuccess:     make([]SuccessCallback, 0),
 		onFailure:     make([]FailureCallback, 0),
+		onIgnored:     make([]IgnoredCallback, 0),
+		onOutcome:     make([]OutcomeCallback, 0),
+		onPanic:       make([]PanicCallback, 0),
 		onRejection:   make([]RejectionCallback, 0),
 	}
 }
@@ -50,6 +84,11 @@ func (c *Callbacks) AddOnTrip(cb TripCallback) {
 	c.onTrip = append(c.onTrip, cb)
 }
 
+// AddOnTripEvent adds a callback for when the circuit trips, receiving the trip details.
+func (c *Callbacks) AddOnTripEvent(cb TripEventCallback) {
+	c.onTripEvent = append(c.onTripEvent, cb)
+}
+
 // AddOnReset adds a callback for when the circuit resets.
 func (c *Callbacks) AddOnReset(cb ResetCallback) {
 	c.onReset = append(c.onReset, cb)
@@ -65,6 +104,21 @@ func (c *Callbacks) AddOnFailure(cb FailureCallback) {
 	c.onFailure = append(c.onFailure, cb)
 }
 
+// AddOnIgnored adds a callback for calls whose outcome is ignored.
+func (c *Callbacks) AddOnIgnored(cb IgnoredCallback) {
+	c.onIgnored = append(c.onIgnored, cb)
+}
+
+// AddOnOutcome adds a callback for the outcome of every executed call.
+func (c *Callbacks) AddOnOutcome(cb OutcomeCallback) {
+	c.onOutcome = append(c.onOutcome, cb)
+}
+
+// AddOnPanic adds a callback for operations that panic.
+func (c *Callbacks) AddOnPanic(cb PanicCallback) {
+	c.onPanic = append(c.onPanic, cb)
+}
+
 // AddOnRejection adds a callback for rejected requests.
 func (c *Callbacks) AddOnRejection(cb RejectionCallback) {
 	c.onRejection = append(c.onRejection, cb)
@@ -84,6 +138,13 @@ func (c *Callbacks) NotifyTrip(name string, err error) {
 	}
 }
 
+// NotifyTripEvent notifies all registered trip event callbacks.
+func (c *Callbacks) NotifyTripEvent(event TripEvent) {
+	for _, cb := range c.onTripEvent {
+		cb(event)
+	}
+}
+
 // NotifyReset notifies all registered reset callbacks.
 func (c *Callbacks) NotifyReset(name string) {
 	for _, cb := range c.onReset {
@@ -105,6 +166,27 @@ func (c *Callbacks) NotifyFailure(name string, err error) {
 	}
 }
 
+// NotifyIgnored notifies all registered ignored callbacks.
+func (c *Callbacks) NotifyIgnored(name string, err error) {
+	for _, cb := range c.onIgnored {
+		cb(name, err)
+	}
+}
+
+// NotifyOutcome notifies all registered outcome callbacks.
+func (c *Callbacks) NotifyOutcome(name string, outcome Outcome, err error) {
+	for _, cb := range c.onOutcome {
+		cb(name, outcome, err)
+	}
+}
+
+// NotifyPanic notifies all registered panic callbacks.
+func (c *Callbacks) NotifyPanic(name string, err *PanicError) {
+	for _, cb := range c.onPanic {
+		cb(name, err)
+	}
+}
+
 // NotifyRejection notifies all registered rejection callbacks.
 func (c *Callbacks) NotifyRejection(name string) {
 	for _, cb := range c.onRejection {
@@ -237,3 +237,35 @@ func TestOnRejection(t *testing.T) {
 		t.Errorf("Callback name should be 'TestBreaker', got '%s'", calledName)
 	}
 }
+
+func TestOnTripEvent(t *testing.T) {
+	cb := NewCallbacks()
+
+	var received TripEvent
+	cb.AddOnTripEvent(func(event TripEvent) {
+		received = event
+	})
+
+	if len(cb.onTripEvent) != 1 {
+		t.Errorf("Should have 1 trip event callback, got %d", len(cb.onTripEvent))
+	}
+
+	testErr := errors.New("test error")
+	threshold := ConsecutiveFailures(3)
+	cb.NotifyTripEvent(TripEvent{
+		Name:      "TestBreaker",
+		Err:       testErr,
+		Threshold: threshold,
+		TrippedBy: []FailureThresholdType{threshold},
+	})
+
+	if received.Name != "TestBreaker" {
+		t.Errorf("Event name should be 'TestBreaker', got '%s'", received.Name)
+	}
+	if received.Err != testErr {
+		t.Errorf("Event error should be %v, got %v", testErr, received.Err)
+	}
+	if len(received.TrippedBy) != 1 {
+		t.Errorf("Event should report 1 threshold, got %d", len(received.TrippedBy))
+	}
+}
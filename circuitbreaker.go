@@ -2,9 +2,15 @@ package gomian
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/nutcase/gomian/internal/clock"
 	"github.com/nutcase/gomian/internal/counter"
 	"github.com/nutcase/gomian/internal/state_machine"
 )
@@ -27,15 +33,32 @@ func convertState(state state_machine.State) State {
 type CircuitBreaker struct {
 	name           string
 	settings       Settings
+	clock          clock.Clock
 	stateMachine   *state_machine.StateMachine
-	rollingWindow  *counter.RollingWindow
+	rollingWindow  counter.Window
 	consecutiveCounter *counter.ConsecutiveCounter
+	latency        *counter.LatencyHistogram
+	failureScore   *counter.EWMA
+	threshold      SnapshotThreshold
 	callbacks      *Callbacks
 	mu             sync.Mutex
-	timer          *time.Timer
+	timer          clock.Timer
 	timerMu        sync.Mutex
-	resetTimer     *time.Timer
+	resetTimer     clock.Timer
 	resetTimerMu   sync.Mutex
+	openDeadline   atomic.Pointer[openDeadline] // used instead of timer when LazyTransitions is set
+	resetDeadline  atomic.Int64 // unix nanoseconds, used instead of resetTimer when LazyTransitions is set
+	staleResults   atomic.Uint64
+	ignoredResults atomic.Uint64
+	outcomes       sync.Map // outcome name -> *atomic.Uint64
+	abandonedCalls atomic.Int64
+	rampUpStart    atomic.Pointer[time.Time] // start of the ramp-up phase, or nil outside one
+	bulkheadRejections atomic.Uint64
+	halfOpenCalls  atomic.Int64 // probe requests in flight, counted when HalfOpenMaxRequests is set
+	probeSuccesses atomic.Uint64 // generation<<32 | successful probes admitted in that generation
+	forced         atomic.Int32 // forcedNone, forcedOpen or forcedClosed
+	lastTripErr    atomic.Pointer[error]
+	rand           func() float64
 }
 
 // Metrics represents the current metrics of a circuit breaker.
@@ -48,6 +71,46 @@ type Metrics struct {
 	ConsecutiveSuccesses uint64
 	LastStateChange     time.Time
 	TimeInState         time.Duration
+
+	// StaleResults is the number of call outcomes that were discarded because
+	// the circuit changed state while the call was in flight.
+	StaleResults        uint64
+
+	// IgnoredResults is the number of calls whose outcome was ignored, and
+	// Outcomes the number of calls by outcome name.
+	IgnoredResults      uint64
+	Outcomes            map[string]uint64
+
+	// AbandonedCalls is the number of calls abandoned after their context was
+	// done, with Settings.AbandonOnTimeout, that are still running.
+	AbandonedCalls      uint64
+
+	// LatencySamples is the number of call durations within the latency window.
+	// The percentiles below are zero unless Settings.LatencyWindow is set.
+	LatencySamples      uint64
+	LatencyP50          time.Duration
+	LatencyP95          time.Duration
+	LatencyP99          time.Duration
+
+	// FailureScore is the exponentially weighted failure score between 0 and 1.
+	// It is zero unless a failure score is tracked.
+	FailureScore        float64
+
+	// ActiveCalls and QueuedCalls are the number of calls running and waiting
+	// in the bulkhead, and BulkheadRejections the number of calls it rejected.
+	// They are zero unless a Bulkhead is used.
+	ActiveCalls         uint64
+	QueuedCalls         uint64
+	BulkheadRejections  uint64
+
+	// RejectionProbability is the probability with which an AdaptiveThrottle
+	// currently rejects requests. It is always zero for a CircuitBreaker.
+	RejectionProbability float64
+
+	// RampingUp reports whether the circuit is Closed but still in the ramp-up
+	// phase configured by Settings.RampUp, admitting only RampUpFraction of requests.
+	RampingUp           bool
+	RampUpFraction      float64
 }
 
 // NewCircuitBreaker creates a new CircuitBreaker with the provided settings.
@@ -59,30 +122,68 @@ func NewCircuitBreaker(settings Settings) *CircuitBreaker {
 	cb := &CircuitBreaker{
 		name:     settings.Name,
 		settings: settings,
+		clock:    clock.OrReal(settings.Clock),
 		callbacks: NewCallbacks(),
 		consecutiveCounter: counter.NewConsecutiveCounter(),
+		rand:     rand.Float64,
+	}
+
+	if settings.FailureThreshold != nil {
+		cb.threshold = AdaptThreshold(settings.FailureThreshold)
 	}
 
 	// Initialize the rolling window if needed
-	if _, ok := settings.FailureThreshold.(FailureRateThreshold); ok {
-		cb.rollingWindow = counter.NewRollingWindow(settings.RollingWindow, 10)
+	if usesWindow(settings.FailureThreshold) {
+		cb.rollingWindow = cb.newWindow()
+	}
+
+	// Initialize the latency histogram if enabled or needed by the threshold
+	if settings.LatencyWindow > 0 || usesLatency(settings.FailureThreshold) {
+		latencyWindow := settings.LatencyWindow
+		if latencyWindow <= 0 {
+			latencyWindow = settings.RollingWindow
+		}
+		if latencyWindow <= 0 {
+			latencyWindow = DefaultSettings().RollingWindow
+		}
+		cb.latency = counter.NewLatencyHistogram(latencyWindow, settings.LatencyWindowBuckets, cb.clock)
+	}
+
+	// Initialize the failure score if enabled or needed by the threshold
+	if settings.FailureScoreHalfLife > 0 || usesFailureScore(settings.FailureThreshold) {
+		halfLife := settings.FailureScoreHalfLife
+		if halfLife <= 0 {
+			halfLife = settings.RollingWindow
+		}
+		if halfLife <= 0 {
+			halfLife = DefaultSettings().RollingWindow
+		}
+		cb.failureScore = counter.NewEWMA(halfLife, cb.clock)
 	}
 
 	// Initialize the state machine
-	cb.stateMachine = state_machine.NewStateMachine(func(from, to state_machine.State) {
+	cb.stateMachine = state_machine.NewStateMachineWithClock(cb.clock, func(from, to state_machine.State) {
 		// Convert state_machine.State to gomian.State
 		fromState := convertState(from)
 		toState := convertState(to)
 		cb.callbacks.NotifyStateChange(cb.name, fromState, toState)
+		if cb.settings.Logger != nil {
+			cb.settings.Logger.Printf("gomian: circuit breaker '%s' changed state: %s -> %s", cb.name, fromState, toState)
+		}
 		
-		// Handle specific state transitions
-		if from == state_machine.Closed && to == state_machine.Open {
-			cb.callbacks.NotifyTrip(cb.name, nil)
-		} else if (from == state_machine.Open || from == state_machine.HalfOpen) && to == state_machine.Closed {
+		// Handle specific state transitions. Trips are notified by the code
+		// that evaluated the threshold, since only it knows the cause.
+		if (from == state_machine.Open || from == state_machine.HalfOpen) && to == state_machine.Closed {
+			cb.startRampUp()
 			cb.callbacks.NotifyReset(cb.name)
+		} else if to == state_machine.Open {
+			cb.stopRampUp()
 		}
 		
-		// Set up timers based on state
+		// Set up timers based on state. The reset timeout only applies while Closed.
+		if from == state_machine.Closed {
+			cb.stopResetTimer()
+		}
 		if to == state_machine.Open {
 			cb.startOpenStateTimer()
 		} else if to == state_machine.Closed && cb.settings.ResetTimeout > 0 {
@@ -98,9 +199,32 @@ func NewCircuitBreaker(settings Settings) *CircuitBreaker {
 	return cb
 }
 
+// newWindow creates the failure rate window selected by the settings.
+func (cb *CircuitBreaker) newWindow() counter.Window {
+	if cb.settings.WindowType == CountWindow {
+		return counter.NewCountWindow(int(cb.settings.WindowCount))
+	}
+	return counter.NewRollingWindowWithClock(cb.settings.RollingWindow, 10, cb.clock)
+}
+
+// openDeadline is when an Open state of the given generation moves on to
+// HalfOpen, in unix nanoseconds, when LazyTransitions is set.
+type openDeadline struct {
+	at         int64
+	generation uint64
+}
+
 // startOpenStateTimer starts a timer that will transition the circuit from Open to HalfOpen
 // after the configured timeout period.
 func (cb *CircuitBreaker) startOpenStateTimer() {
+	if cb.settings.LazyTransitions {
+		cb.openDeadline.Store(&openDeadline{
+			at:         cb.clock.Now().Add(cb.settings.Timeout).UnixNano(),
+			generation: cb.stateMachine.Generation(),
+		})
+		return
+	}
+
 	cb.timerMu.Lock()
 	defer cb.timerMu.Unlock()
 
@@ -109,14 +233,37 @@ func (cb *CircuitBreaker) startOpenStateTimer() {
 		cb.timer.Stop()
 	}
 
-	cb.timer = time.AfterFunc(cb.settings.Timeout, func() {
-		cb.stateMachine.TransitionToHalfOpen()
+	// Only move on if the circuit is still in the Open state that started the
+	// timer, and was not forced Open
+	generation := cb.stateMachine.Generation()
+	cb.timer = cb.clock.AfterFunc(cb.settings.Timeout, func() {
+		if cb.forced.Load() != forcedOpen {
+			cb.stateMachine.CompareAndTransition(generation, state_machine.HalfOpen)
+		}
 	})
 }
 
+// stopOpenStateTimer cancels any pending transition from Open to HalfOpen.
+func (cb *CircuitBreaker) stopOpenStateTimer() {
+	cb.openDeadline.Store(nil)
+
+	cb.timerMu.Lock()
+	defer cb.timerMu.Unlock()
+
+	if cb.timer != nil {
+		cb.timer.Stop()
+		cb.timer = nil
+	}
+}
+
 // startResetTimer starts a timer that will reset the failure counters if no failures
 // occur within the configured reset timeout period.
 func (cb *CircuitBreaker) startResetTimer() {
+	if cb.settings.LazyTransitions {
+		cb.resetDeadline.Store(cb.clock.Now().Add(cb.settings.ResetTimeout).UnixNano())
+		return
+	}
+
 	cb.resetTimerMu.Lock()
 	defer cb.resetTimerMu.Unlock()
 
@@ -125,22 +272,82 @@ func (cb *CircuitBreaker) startResetTimer() {
 		cb.resetTimer.Stop()
 	}
 
-	cb.resetTimer = time.AfterFunc(cb.settings.ResetTimeout, func() {
-		cb.mu.Lock()
-		defer cb.mu.Unlock()
+	cb.resetTimer = cb.clock.AfterFunc(cb.settings.ResetTimeout, cb.resetCounters)
+}
+
+// stopResetTimer cancels any pending reset of the failure counters.
+func (cb *CircuitBreaker) stopResetTimer() {
+	cb.resetDeadline.Store(0)
 
-		// Only reset if we're still in the Closed state
-		if cb.stateMachine.IsClosed() {
-			cb.consecutiveCounter.Reset()
-			if cb.rollingWindow != nil {
-				cb.rollingWindow.Reset()
-			}
-		}
-	})
+	cb.resetTimerMu.Lock()
+	defer cb.resetTimerMu.Unlock()
+
+	if cb.resetTimer != nil {
+		cb.resetTimer.Stop()
+		cb.resetTimer = nil
+	}
+}
+
+// resetCounters clears the failure counters if the circuit is still Closed.
+// It runs when the reset timeout expires.
+func (cb *CircuitBreaker) resetCounters() {
+	// The serialized Half-Open probe holds cb.mu, and may itself evaluate an
+	// expired deadline, e.g. by calling State, so never wait for it outside Closed
+	if !cb.stateMachine.IsClosed() {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	// Only reset if we're still in the Closed state
+	if cb.stateMachine.IsClosed() {
+		cb.resetWindows()
+	}
+}
+
+// resetWindows clears the consecutive counter and every window that is kept,
+// including the latency samples.
+func (cb *CircuitBreaker) resetWindows() {
+	cb.consecutiveCounter.Reset()
+	if cb.rollingWindow != nil {
+		cb.rollingWindow.Reset()
+	}
+	if cb.latency != nil {
+		cb.latency.Reset()
+	}
+	if cb.failureScore != nil {
+		cb.failureScore.Reset()
+	}
+}
+
+// evaluateDeadlines applies any expired Open timeout or reset timeout when
+// LazyTransitions is set. It does the work the timers would have done had
+// they fired. The deadlines are claimed with a compare-and-swap so that only
+// one caller acts on each of them.
+func (cb *CircuitBreaker) evaluateDeadlines() {
+	if !cb.settings.LazyTransitions {
+		return
+	}
+
+	now := cb.clock.Now().UnixNano()
+
+	// Like the timer, only move on if the circuit is still in the Open state
+	// that set the deadline
+	if deadline := cb.openDeadline.Load(); deadline != nil && now >= deadline.at &&
+		cb.openDeadline.CompareAndSwap(deadline, nil) && cb.forced.Load() != forcedOpen {
+		cb.stateMachine.CompareAndTransition(deadline.generation, state_machine.HalfOpen)
+	}
+
+	if deadline := cb.resetDeadline.Load(); deadline != 0 && now >= deadline &&
+		cb.resetDeadline.CompareAndSwap(deadline, 0) {
+		cb.resetCounters()
+	}
 }
 
 // Execute executes the given function if the circuit is closed or half-open.
-// If the circuit is open, it returns ErrCircuitOpen without executing the function.
+// If the circuit is open, it returns a *CircuitError matching ErrCircuitOpen
+// without executing the function.
 func (cb *CircuitBreaker) Execute(op func() error) error {
 	return cb.ExecuteContext(context.Background(), func(ctx context.Context) error {
 		return op()
@@ -148,81 +355,401 @@ func (cb *CircuitBreaker) Execute(op func() error) error {
 }
 
 // ExecuteContext executes the given function with context if the circuit is closed or half-open.
-// If the circuit is open, it returns ErrCircuitOpen without executing the function.
+// If the circuit is open, it returns a *CircuitError matching ErrCircuitOpen
+// without executing the function.
 func (cb *CircuitBreaker) ExecuteContext(ctx context.Context, op func(context.Context) error) error {
+	_, err := cb.execute(ctx, op)
+	return err
+}
+
+// execute implements ExecuteContext, and also returns the outcome the call
+// was classified as. The outcome is that of a success if the call was not
+// made, e.g. because it was rejected, and a stale result is only classified
+// if it is an error.
+func (cb *CircuitBreaker) execute(ctx context.Context, op func(context.Context) error) (Outcome, error) {
 	if ctx.Err() != nil {
-		return ctx.Err()
+		return Success, ctx.Err()
 	}
 
-	state := cb.stateMachine.State()
+	cb.evaluateDeadlines()
+	state, generation := cb.stateMachine.Snapshot()
 
 	// If the circuit is open, reject the request
 	if state == state_machine.Open {
-		cb.callbacks.NotifyRejection(cb.name)
-		return ErrCircuitOpen
+		return Success, cb.reject(state, cb.openReason())
+	}
+
+	// Right after closing, only admit a growing fraction of requests
+	if state == state_machine.Closed && !cb.admitDuringRampUp() {
+		return Success, cb.reject(state, ReasonRampUp)
 	}
 
-	// If the circuit is half-open, only allow one request at a time
+	// If the circuit is half-open, admit up to HalfOpenMaxRequests probes, or
+	// only one at a time if unset
 	if state == state_machine.HalfOpen {
-		cb.mu.Lock()
-		defer cb.mu.Unlock()
+		if limit := cb.settings.HalfOpenMaxRequests; limit > 0 {
+			if cb.halfOpenCalls.Add(1) > int64(limit) {
+				cb.halfOpenCalls.Add(-1)
+				return Success, cb.reject(state, ReasonHalfOpenFull)
+			}
+			defer cb.halfOpenCalls.Add(-1)
+		} else {
+			cb.mu.Lock()
+			defer cb.mu.Unlock()
+
+			// The probe ahead of this one may have settled the Half-Open state
+			// meanwhile, so do not call a dependency that just failed again
+			if current, g := cb.stateMachine.Snapshot(); g != generation {
+				if current == state_machine.Open {
+					return Success, cb.reject(current, cb.openReason())
+				}
+				return Success, cb.reject(state, ReasonHalfOpenFull)
+			}
+		}
+	}
+
+	// Wait for a slot if a bulkhead is attached. The slot is released once op
+	// returns, even if the call was abandoned before, so that abandoned calls
+	// still count against the concurrency limit.
+	if bulkhead := cb.settings.Bulkhead; bulkhead != nil {
+		if err := bulkhead.acquire(ctx); err != nil {
+			return Success, cb.recordBulkheadRejection(err, generation)
+		}
+		inner := op
+		op = func(ctx context.Context) error {
+			defer bulkhead.release()
+			return inner(ctx)
+		}
 	}
 
 	// Execute the operation
-	err := op(ctx)
+	var start time.Time
+	if cb.latency != nil {
+		start = cb.clock.Now()
+	}
+	err := cb.call(ctx, op)
+	var elapsed time.Duration
+	if cb.latency != nil {
+		elapsed = cb.clock.Since(start)
+	}
+
+	// Panic again once the outcome has been recorded, and after any other
+	// deferred cleanup such as releasing the Half-Open lock
+	if pe, ok := err.(*PanicError); ok && cb.settings.PanicPolicy == PanicPropagate {
+		defer func() {
+			panic(pe.Value)
+		}()
+	}
+
+	// Discard the result if the circuit changed state while the call was in
+	// flight, so that it cannot affect the new state
+	if cb.stateMachine.Generation() != generation {
+		cb.recordLatency(elapsed)
+		cb.staleResults.Add(1)
+		if err != nil {
+			return cb.classifyCall(ctx, err), err
+		}
+		return Success, nil
+	}
 
 	// Record the result
-	if err != nil {
-		if cb.isFailure(err) {
-			cb.recordFailure(err)
+	outcome := cb.classifyCall(ctx, err)
+	cb.countOutcome(outcome)
+	cb.callbacks.NotifyOutcome(cb.name, outcome, err)
+
+	switch outcome.Kind {
+	case KindIgnore:
+		cb.ignoredResults.Add(1)
+		cb.callbacks.NotifyIgnored(cb.name, err)
+	case KindSlow:
+		cb.recordLatency(elapsed)
+		cb.recordSlow(err, generation)
+	case KindFailure:
+		cb.recordLatency(elapsed)
+		weight := outcome.Weight
+		if weight == 0 {
+			weight = cb.failureWeight(err)
 		}
+		if weight > 0 {
+			cb.recordFailure(err, weight, generation)
+		} else {
+			cb.recordSuccess(generation)
+		}
+	default:
+		cb.recordLatency(elapsed)
+		cb.recordSuccess(generation)
+	}
+	return outcome, err
+}
+
+// openReason returns why a request is rejected while the circuit is Open.
+func (cb *CircuitBreaker) openReason() RejectionReason {
+	if cb.forced.Load() == forcedOpen {
+		return ReasonForcedOpen
+	}
+	return ReasonOpen
+}
+
+// reject notifies the rejection of a request in the given state and returns
+// the *CircuitError describing it.
+func (cb *CircuitBreaker) reject(state state_machine.State, reason RejectionReason) error {
+	cb.callbacks.NotifyRejection(cb.name)
+
+	err := &CircuitError{
+		Name:   cb.name,
+		Err:    ErrCircuitOpen,
+		State:  convertState(state),
+		Reason: reason,
+	}
+	if reason == ReasonRampUp {
+		err.Err = ErrRampUpRejected
+	}
+	if reason == ReasonOpen {
+		err.RetryAfter = max(cb.settings.Timeout-cb.stateMachine.TimeInState(), 0)
+	}
+	if tripErr := cb.lastTripErr.Load(); tripErr != nil {
+		err.LastTripErr = *tripErr
+	}
+	return err
+}
+
+// recordBulkheadRejection records a call that did not get a slot in the
+// bulkhead and returns err. A call whose context was done while queued is not
+// counted, like one whose context was done before it was admitted.
+func (cb *CircuitBreaker) recordBulkheadRejection(err error, generation uint64) error {
+	if err != ErrBulkheadFull {
 		return err
 	}
+	cb.bulkheadRejections.Add(1)
+
+	outcome := Outcome{Name: "BulkheadFull", Kind: KindIgnore}
+	if cb.settings.BulkheadFailures {
+		outcome.Kind = KindFailure
+	}
+	cb.countOutcome(outcome)
+	cb.callbacks.NotifyOutcome(cb.name, outcome, err)
 
-	cb.recordSuccess()
-	return nil
+	if outcome.Kind == KindFailure {
+		cb.recordFailure(err, cb.failureWeight(err), generation)
+	} else {
+		cb.ignoredResults.Add(1)
+		cb.callbacks.NotifyIgnored(cb.name, err)
+	}
+	return err
+}
+
+// call runs op, enforcing the CallTimeout if one is set. A panic in op is
+// returned as a *PanicError. A call that is still running when its timeout
+// expires returns an error wrapping ErrCallTimeout, whatever its result. With AbandonOnTimeout, call returns as soon as the
+// call's context is done and leaves op running in the background.
+func (cb *CircuitBreaker) call(ctx context.Context, op func(context.Context) error) error {
+	callCtx := ctx
+	if cb.settings.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = clock.WithTimeoutCause(ctx, cb.clock, cb.settings.CallTimeout, ErrCallTimeout)
+		defer cancel()
+	}
+
+	var err error
+	if cb.settings.AbandonOnTimeout {
+		err = cb.callAbandonable(callCtx, op)
+	} else {
+		err = cb.runOp(callCtx, op)
+	}
+	if _, ok := err.(*PanicError); ok {
+		return err
+	}
+
+	return callTimeoutError(ctx, callCtx, err)
+}
+
+// callTimeoutError returns the error of a call made with callCtx, derived from
+// ctx with a timeout whose cause is ErrCallTimeout. If the timeout expired,
+// it returns ErrCallTimeout, wrapping err if the call returned one. The
+// caller's own cancellation takes precedence over the call timeout.
+func callTimeoutError(ctx, callCtx context.Context, err error) error {
+	if ctx.Err() == nil && context.Cause(callCtx) == ErrCallTimeout {
+		if err == nil || errors.Is(err, ErrCallTimeout) {
+			return ErrCallTimeout
+		}
+		return fmt.Errorf("%w: %w", ErrCallTimeout, err)
+	}
+	return err
+}
+
+// runOp calls op, recovering a panic into a *PanicError.
+func (cb *CircuitBreaker) runOp(ctx context.Context, op func(context.Context) error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			pe := &PanicError{Value: v, Stack: debug.Stack()}
+			cb.callbacks.NotifyPanic(cb.name, pe)
+			err = pe
+		}
+	}()
+	return op(ctx)
+}
+
+// Call states used to decide whether a call was abandoned.
+const (
+	callRunning int32 = iota
+	callFinished
+	callAbandoned
+)
+
+// callAbandonable runs op in its own goroutine and waits for it or for ctx to
+// be done, whichever comes first. An abandoned call is counted in
+// abandonedCalls until op returns.
+func (cb *CircuitBreaker) callAbandonable(ctx context.Context, op func(context.Context) error) error {
+	var state atomic.Int32
+	done := make(chan error, 1)
+
+	go func() {
+		err := cb.runOp(ctx, op)
+		if !state.CompareAndSwap(callRunning, callFinished) {
+			cb.abandonedCalls.Add(-1)
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// Count the call before abandoning it, so that the count never drops below zero
+		cb.abandonedCalls.Add(1)
+		if state.CompareAndSwap(callRunning, callAbandoned) {
+			return ctx.Err()
+		}
+		cb.abandonedCalls.Add(-1)
+		return <-done
+	}
+}
+
+// recordLatency records the duration of a call if latency is tracked.
+func (cb *CircuitBreaker) recordLatency(elapsed time.Duration) {
+	if cb.latency != nil {
+		cb.latency.Record(elapsed)
+	}
 }
 
 // ExecuteWithFallback executes the given function if the circuit is closed or half-open.
-// If the circuit is open or if the function fails, it executes the fallback function.
+// If the request is rejected or the function fails, it executes the fallback function.
+// Errors that are not failures, such as ignored errors, are returned as they are.
 func (cb *CircuitBreaker) ExecuteWithFallback(op func() error, fallback func(error) error) error {
-	err := cb.Execute(op)
-	if err != nil {
+	return cb.ExecuteWithFallbackContext(context.Background(), func(ctx context.Context) error {
+		return op()
+	}, func(ctx context.Context, err error) error {
 		return fallback(err)
-	}
-	return nil
+	})
 }
 
 // ExecuteWithFallbackContext executes the given function with context if the circuit is closed or half-open.
-// If the circuit is open or if the function fails, it executes the fallback function.
+// If the request is rejected or the function fails, it executes the fallback function.
+// Errors that are not failures, such as ignored errors, are returned as they are.
 func (cb *CircuitBreaker) ExecuteWithFallbackContext(ctx context.Context, op func(context.Context) error, fallback func(context.Context, error) error) error {
-	err := cb.ExecuteContext(ctx, op)
-	if err != nil {
+	outcome, err := cb.execute(ctx, op)
+	if err != nil && (isRejection(err) || outcome.Kind == KindFailure) {
 		return fallback(ctx, err)
 	}
-	return nil
+	return err
+}
+
+// classify determines the outcome of a call from its error.
+func (cb *CircuitBreaker) classify(err error) Outcome {
+	if cb.settings.Classify != nil {
+		return cb.settings.Classify(err)
+	}
+
+	if err != nil {
+		for _, o := range cb.settings.Outcomes {
+			if o.Match(err) {
+				return o.Outcome
+			}
+		}
+	}
+
+	return defaultOutcome(err, cb.settings.IsFailure, cb.settings.IgnoredErrors)
+}
+
+// classifyCall determines the outcome of a call made with ctx. Panics and call
+// timeouts are always failures, and other context errors are handled according to where
+// they came from, before any other classification.
+func (cb *CircuitBreaker) classifyCall(ctx context.Context, err error) Outcome {
+	if _, ok := err.(*PanicError); ok {
+		return Outcome{Name: "Panic", Kind: KindFailure}
+	}
+	if errors.Is(err, ErrCallTimeout) {
+		return Outcome{Name: CallTimeoutContext.String(), Kind: KindFailure}
+	}
+	if outcome, ok := cb.classifyContextError(ctx, err); ok {
+		return outcome
+	}
+	return cb.classify(err)
+}
+
+// classifyContextError classifies context.Canceled and context.DeadlineExceeded
+// according to the policy for their source. It returns false if err is not a
+// context error or its policy defers to the regular classification.
+func (cb *CircuitBreaker) classifyContextError(ctx context.Context, err error) (Outcome, bool) {
+	if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return Outcome{}, false
+	}
+
+	// The caller's context being done means the caller gave up, not the dependency
+	source, policy := DependencyContext, cb.settings.DependencyContextErrors
+	if ctx.Err() != nil {
+		source, policy = CallerContext, cb.settings.CallerContextErrors
+	}
+
+	outcome := Outcome{Name: source.String()}
+	switch policy {
+	case ContextErrorIgnore:
+		outcome.Kind = KindIgnore
+	case ContextErrorFailure:
+		outcome.Kind = KindFailure
+	case ContextErrorSlow:
+		outcome.Kind = KindSlow
+	default:
+		if source != CallerContext {
+			return Outcome{}, false
+		}
+		outcome.Kind = KindIgnore
+	}
+	return outcome, true
 }
 
 // isFailure determines if an error should be considered a failure.
 func (cb *CircuitBreaker) isFailure(err error) bool {
-	// If a custom IsFailure function is provided, use it
-	if cb.settings.IsFailure != nil {
-		return cb.settings.IsFailure(err)
+	return cb.classify(err).Kind == KindFailure
+}
+
+// countOutcome increments the number of calls with the outcome's name.
+func (cb *CircuitBreaker) countOutcome(outcome Outcome) {
+	count, ok := cb.outcomes.Load(outcome.Name)
+	if !ok {
+		count, _ = cb.outcomes.LoadOrStore(outcome.Name, new(atomic.Uint64))
 	}
+	count.(*atomic.Uint64).Add(1)
+}
 
-	// Check if the error is in the ignored errors list
-	for _, ignoredErr := range cb.settings.IgnoredErrors {
-		if err == ignoredErr {
-			return false
-		}
+// failureWeight returns how much a failure counts towards the failure threshold.
+func (cb *CircuitBreaker) failureWeight(err error) float64 {
+	if cb.settings.FailureWeight != nil {
+		return cb.settings.FailureWeight(err)
 	}
 
-	// By default, any non-nil error is a failure
-	return err != nil
+	for _, w := range cb.settings.FailureWeights {
+		if w.Match(err) {
+			return w.Weight
+		}
+	}
+	return 1
 }
 
 // recordSuccess records a successful request and updates the circuit state if necessary.
-func (cb *CircuitBreaker) recordSuccess() {
+// generation is the state machine generation under which the request was admitted;
+// the circuit is only closed if it has not changed since.
+func (cb *CircuitBreaker) recordSuccess(generation uint64) {
 	cb.callbacks.NotifySuccess(cb.name)
 
 	// Update counters
@@ -230,18 +757,27 @@ func (cb *CircuitBreaker) recordSuccess() {
 	if cb.rollingWindow != nil {
 		cb.rollingWindow.IncrementSuccess()
 	}
+	if cb.failureScore != nil {
+		cb.failureScore.IncrementSuccess()
+	}
+
+	// Thresholds that look at more than failures, such as latency, may trip
+	// the circuit on a successful but slow call
+	if cb.stateMachine.IsClosed() && cb.threshold != nil && !tripsOnFailureOnly(cb.settings.FailureThreshold) {
+		cb.tripIfThresholdReached(nil, generation)
+		return
+	}
 
 	// If we're in the half-open state and have reached the success threshold,
 	// transition to closed
 	if cb.stateMachine.IsHalfOpen() && 
-	   cb.consecutiveCounter.ConsecutiveSuccesses() >= cb.settings.SuccessThreshold {
-		cb.stateMachine.TransitionToClosed()
+	   cb.countProbeSuccess(generation) >= cb.settings.SuccessThreshold {
+		if !cb.stateMachine.CompareAndTransition(generation, state_machine.Closed) {
+			return
+		}
 		
 		// Reset counters
-		cb.consecutiveCounter.Reset()
-		if cb.rollingWindow != nil {
-			cb.rollingWindow.Reset()
-		}
+		cb.resetWindows()
 		
 		// Start the reset timer if configured
 		if cb.settings.ResetTimeout > 0 {
@@ -250,44 +786,116 @@ func (cb *CircuitBreaker) recordSuccess() {
 	}
 }
 
-// recordFailure records a failed request and updates the circuit state if necessary.
-func (cb *CircuitBreaker) recordFailure(err error) {
+// countProbeSuccess counts a success admitted under generation towards the
+// success threshold and returns the number of successes counted for that
+// generation. Successes are counted per generation with a compare-and-swap,
+// so that a stale success admitted before the circuit last tripped never
+// counts towards a later Half-Open state.
+func (cb *CircuitBreaker) countProbeSuccess(generation uint64) uint64 {
+	for {
+		word := cb.probeSuccesses.Load()
+		counted, n := word>>32, word&(1<<32-1)
+		if generation < counted {
+			return 0
+		}
+		if generation > counted {
+			n = 0
+		}
+		n++
+		if cb.probeSuccesses.CompareAndSwap(word, generation<<32|n) {
+			return n
+		}
+	}
+}
+
+// recordSlow records a call that was slow rather than failed. It is not counted
+// as a success or a failure, but its duration may trip a latency threshold.
+func (cb *CircuitBreaker) recordSlow(err error, generation uint64) {
+	if cb.stateMachine.IsClosed() && cb.threshold != nil && !tripsOnFailureOnly(cb.settings.FailureThreshold) {
+		cb.tripIfThresholdReached(err, generation)
+	}
+}
+
+// recordFailure records a failed request weighing weight and updates the circuit
+// state if necessary. generation is the state machine generation under which the request was admitted;
+// the circuit is only tripped if it has not changed since.
+func (cb *CircuitBreaker) recordFailure(err error, weight float64, generation uint64) {
 	cb.callbacks.NotifyFailure(cb.name, err)
 
 	// Update counters
-	cb.consecutiveCounter.IncrementFailure()
+	cb.consecutiveCounter.AddFailure(weight)
 	if cb.rollingWindow != nil {
-		cb.rollingWindow.IncrementFailure()
+		cb.rollingWindow.AddFailure(weight)
+	}
+	if cb.failureScore != nil {
+		cb.failureScore.AddFailure(weight)
 	}
 
 	// If we're in the half-open state, any failure should trip the circuit
 	if cb.stateMachine.IsHalfOpen() {
-		cb.stateMachine.TransitionToOpen()
+		if cb.stateMachine.CompareAndTransition(generation, state_machine.Open) {
+			cb.lastTripErr.Store(&err)
+		}
 		return
 	}
 
 	// If we're in the closed state, check if we should trip the circuit
-	if cb.stateMachine.IsClosed() {
-		shouldTrip := false
-
-		// Check if we should trip based on the failure threshold type
-		switch threshold := cb.settings.FailureThreshold.(type) {
-		case ConsecutiveFailuresThreshold:
-			shouldTrip = cb.consecutiveCounter.ConsecutiveFailures() >= threshold.Threshold
-		case FailureRateThreshold:
-			if cb.rollingWindow != nil {
-				requests, failures := cb.rollingWindow.Counts()
-				if requests >= cb.settings.MinimumRequestVolume {
-					shouldTrip = threshold.ShouldTrip(failures, 0, requests, cb.settings.RollingWindow)
-				}
-			}
-		}
+	if cb.stateMachine.IsClosed() && cb.threshold != nil {
+		cb.tripIfThresholdReached(err, generation)
+	}
+}
+
+// tripIfThresholdReached evaluates the failure threshold and opens the circuit
+// if it is reached and the state has not changed since generation. err is the
+// error of the call that was just recorded, or nil for a successful call.
+func (cb *CircuitBreaker) tripIfThresholdReached(err error, generation uint64) {
+	if cb.forced.Load() == forcedClosed {
+		return
+	}
 
-		if shouldTrip {
-			cb.stateMachine.TransitionToOpen()
-			cb.callbacks.NotifyTrip(cb.name, err)
+	trip, trippedBy := evaluateThreshold(cb.threshold, cb.snapshot())
+	if !trip || !cb.stateMachine.CompareAndTransition(generation, state_machine.Open) {
+		return
+	}
+	cb.lastTripErr.Store(&err)
+
+	cb.callbacks.NotifyTrip(cb.name, err)
+	cb.callbacks.NotifyTripEvent(TripEvent{
+		Name:      cb.name,
+		Err:       err,
+		Threshold: cb.settings.FailureThreshold,
+		TrippedBy: trippedBy,
+	})
+}
+
+// snapshot captures the counters that thresholds are evaluated against.
+func (cb *CircuitBreaker) snapshot() WindowSnapshot {
+	s := WindowSnapshot{
+		ConsecutiveFailures:         cb.consecutiveCounter.ConsecutiveFailures(),
+		ConsecutiveSuccesses:        cb.consecutiveCounter.ConsecutiveSuccesses(),
+		WeightedConsecutiveFailures: cb.consecutiveCounter.ConsecutiveFailureWeight(),
+		MinimumRequestVolume:        cb.settings.MinimumRequestVolume,
+		latency:                     cb.latency,
+	}
+
+	if cb.failureScore != nil {
+		s.FailureScore, s.FailureScoreVolume = cb.failureScore.Score()
+	}
+
+	if cb.rollingWindow != nil {
+		s.Total, s.Failures = cb.rollingWindow.Counts()
+		s.Successes = s.Total - s.Failures
+		s.WeightedFailures = cb.rollingWindow.WeightedFailures()
+		if cb.settings.WindowType == TimeWindow {
+			s.Window = cb.settings.RollingWindow
 		}
+	} else {
+		s.Successes, s.Failures = cb.consecutiveCounter.Totals()
+		s.Total = s.Successes + s.Failures
+		s.WeightedFailures = cb.consecutiveCounter.TotalFailureWeight()
 	}
+
+	return s
 }
 
 // OnStateChange registers a callback for state changes.
@@ -300,6 +908,12 @@ func (cb *CircuitBreaker) OnTrip(callback TripCallback) {
 	cb.callbacks.AddOnTrip(callback)
 }
 
+// OnTripEvent registers a callback for when the circuit trips, receiving
+// the thresholds that caused the trip.
+func (cb *CircuitBreaker) OnTripEvent(callback TripEventCallback) {
+	cb.callbacks.AddOnTripEvent(callback)
+}
+
 // OnReset registers a callback for when the circuit resets.
 func (cb *CircuitBreaker) OnReset(callback ResetCallback) {
 	cb.callbacks.AddOnReset(callback)
@@ -315,6 +929,21 @@ func (cb *CircuitBreaker) OnFailure(callback FailureCallback) {
 	cb.callbacks.AddOnFailure(callback)
 }
 
+// OnIgnored registers a callback for calls whose outcome is ignored.
+func (cb *CircuitBreaker) OnIgnored(callback IgnoredCallback) {
+	cb.callbacks.AddOnIgnored(callback)
+}
+
+// OnOutcome registers a callback receiving the outcome of every executed call.
+func (cb *CircuitBreaker) OnOutcome(callback OutcomeCallback) {
+	cb.callbacks.AddOnOutcome(callback)
+}
+
+// OnPanic registers a callback for operations that panic.
+func (cb *CircuitBreaker) OnPanic(callback PanicCallback) {
+	cb.callbacks.AddOnPanic(callback)
+}
+
 // OnRejection registers a callback for rejected requests.
 func (cb *CircuitBreaker) OnRejection(callback RejectionCallback) {
 	cb.callbacks.AddOnRejection(callback)
@@ -327,11 +956,52 @@ func (cb *CircuitBreaker) Name() string {
 
 // State returns the current state of the circuit breaker.
 func (cb *CircuitBreaker) State() State {
+	cb.evaluateDeadlines()
 	return convertState(cb.stateMachine.State())
 }
 
+// Forced modes set by ForceOpen and ForceClosed.
+const (
+	forcedNone int32 = iota
+	forcedOpen
+	forcedClosed
+)
+
+// ForceOpen opens the circuit and keeps it Open, rejecting every request with
+// ReasonForcedOpen, until ForceClosed or Reset is called.
+func (cb *CircuitBreaker) ForceOpen() {
+	cb.forced.Store(forcedOpen)
+	cb.stateMachine.TransitionToOpen()
+	cb.stopOpenStateTimer()
+}
+
+// ForceClosed closes the circuit and keeps it Closed, admitting every request
+// however many fail, until ForceOpen or Reset is called.
+func (cb *CircuitBreaker) ForceClosed() {
+	cb.forced.Store(forcedClosed)
+	cb.stateMachine.TransitionToClosed()
+	cb.stopOpenStateTimer()
+	cb.stopRampUp()
+}
+
+// Reset closes the circuit, clears its counters and latency samples and
+// returns it to normal operation, undoing ForceOpen and ForceClosed.
+//
+// Like ForceOpen and ForceClosed, it does not wait for a Half-Open probe in
+// flight: closing the circuit starts a new generation, so the probe's result
+// is discarded as stale.
+func (cb *CircuitBreaker) Reset() {
+	cb.forced.Store(forcedNone)
+	cb.stateMachine.TransitionToClosed()
+	cb.stopOpenStateTimer()
+	cb.stopRampUp()
+	cb.resetWindows()
+}
+
 // GetMetrics returns the current metrics of the circuit breaker.
 func (cb *CircuitBreaker) GetMetrics() Metrics {
+	cb.evaluateDeadlines()
+
 	var totalRequests, totalFailures uint64
 	
 	if cb.rollingWindow != nil {
@@ -340,7 +1010,7 @@ func (cb *CircuitBreaker) GetMetrics() Metrics {
 		totalRequests, totalFailures = cb.consecutiveCounter.Totals()
 	}
 	
-	return Metrics{
+	metrics := Metrics{
 		Name:                cb.name,
 		State:               convertState(cb.stateMachine.State()),
 		TotalRequests:       totalRequests,
@@ -349,7 +1019,39 @@ func (cb *CircuitBreaker) GetMetrics() Metrics {
 		ConsecutiveSuccesses: cb.consecutiveCounter.ConsecutiveSuccesses(),
 		LastStateChange:     cb.stateMachine.LastStateChange(),
 		TimeInState:         cb.stateMachine.TimeInState(),
+		StaleResults:        cb.staleResults.Load(),
+		IgnoredResults:      cb.ignoredResults.Load(),
+		AbandonedCalls:      uint64(max(cb.abandonedCalls.Load(), 0)),
+		Outcomes:            make(map[string]uint64),
+		RampUpFraction:      cb.currentRampUpFraction(),
+		BulkheadRejections:  cb.bulkheadRejections.Load(),
 	}
+	metrics.RampingUp = metrics.State == Closed && metrics.RampUpFraction < 1
+
+	cb.outcomes.Range(func(name, count any) bool {
+		metrics.Outcomes[name.(string)] = count.(*atomic.Uint64).Load()
+		return true
+	})
+
+	if cb.failureScore != nil {
+		metrics.FailureScore, _ = cb.failureScore.Score()
+	}
+
+	if bulkhead := cb.settings.Bulkhead; bulkhead != nil {
+		bulkheadMetrics := bulkhead.GetMetrics()
+		metrics.ActiveCalls = bulkheadMetrics.ActiveCalls
+		metrics.QueuedCalls = bulkheadMetrics.QueuedCalls
+	}
+
+	if cb.latency != nil {
+		percentiles, samples := cb.latency.Percentiles(50, 95, 99)
+		metrics.LatencySamples = samples
+		metrics.LatencyP50 = percentiles[0]
+		metrics.LatencyP95 = percentiles[1]
+		metrics.LatencyP99 = percentiles[2]
+	}
+
+	return metrics
 }
 
 // Close stops all timers and releases resources.
@@ -3,9 +3,12 @@ package gomian
 import (
 	"context"
 	"errors"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/nutcase/gomian/clocktest"
 )
 
 func TestNewCircuitBreaker(t *testing.T) {
@@ -30,11 +33,14 @@ func TestNewCircuitBreaker(t *testing.T) {
 }
 
 func TestCircuitBreakerExecute(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+
 	// Create a circuit breaker with a low threshold
 	settings := Settings{
 		Name:             "TestBreaker",
 		FailureThreshold: ConsecutiveFailures(2),
 		Timeout:          100 * time.Millisecond,
+		Clock:            clk,
 	}
 	
 	cb := NewCircuitBreaker(settings)
@@ -89,8 +95,8 @@ func TestCircuitBreakerExecute(t *testing.T) {
 		t.Errorf("Execute should return ErrCircuitOpen when circuit is open, got: %v", err)
 	}
 	
-	// Wait for timeout to transition to half-open
-	time.Sleep(150 * time.Millisecond)
+	// Advance past the timeout to transition to half-open
+	clk.Advance(150 * time.Millisecond)
 	
 	// Test half-open state
 	if cb.State() != HalfOpen {
@@ -457,6 +463,7 @@ func TestCircuitBreakerReset(t *testing.T) {
 		Name:             "TestBreaker",
 		FailureThreshold: ConsecutiveFailures(2),
 		Timeout:          1 * time.Hour, // Long timeout to prevent auto-transition
+		LatencyWindow:    time.Minute,
 	}
 	
 	cb := NewCircuitBreaker(settings)
@@ -475,14 +482,15 @@ func TestCircuitBreakerReset(t *testing.T) {
 		t.Errorf("Circuit should be open, got %v", cb.State())
 	}
 	
-	// Manually transition the circuit back to closed state
-	// since there's no Reset method in the current implementation
-	cb.stateMachine.TransitionToClosed()
+	cb.Reset()
 	
 	// Circuit should be closed
 	if cb.State() != Closed {
 		t.Errorf("Circuit should be closed after reset, got %v", cb.State())
 	}
+	if metrics := cb.GetMetrics(); metrics.ConsecutiveFailures != 0 || metrics.TotalFailures != 0 || metrics.LatencySamples != 0 {
+		t.Errorf("Counters should be cleared after reset, got %+v", metrics)
+	}
 	
 	// Execute should work again
 	err := cb.Execute(func() error {
@@ -494,20 +502,54 @@ func TestCircuitBreakerReset(t *testing.T) {
 	}
 }
 
+func TestCircuitBreakerResetDuringProbe(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb := NewCircuitBreaker(Settings{
+		Name:             "TestBreaker",
+		FailureThreshold: ConsecutiveFailures(1),
+		Timeout:          time.Second,
+		Clock:            clk,
+	})
+	defer cb.Close()
+
+	cb.Execute(func() error { return errors.New("failure") })
+	clk.Advance(time.Second)
+
+	// Reset must not wait for the Half-Open probe, even from within it
+	done := make(chan error, 1)
+	go func() {
+		done <- cb.Execute(func() error {
+			cb.Reset()
+			return errors.New("failure")
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reset should not block on the Half-Open probe")
+	}
+
+	// The probe's failure belongs to the Half-Open state and is discarded
+	if metrics := cb.GetMetrics(); metrics.State != Closed || metrics.StaleResults != 1 {
+		t.Errorf("Circuit should stay closed with 1 stale result, got %+v", metrics)
+	}
+}
+
 func TestCircuitBreakerForceOpen(t *testing.T) {
-	t.Skip("ForceOpen method doesn't exist in the current implementation")
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+
 	// Create a circuit breaker
 	settings := Settings{
 		Name:             "TestBreaker",
 		FailureThreshold: ConsecutiveFailures(5),
 		Timeout:          1 * time.Second,
+		Clock:            clk,
 	}
 	
 	cb := NewCircuitBreaker(settings)
 	
-	// Force the circuit open by directly accessing the state machine
-	// Note: This is a test-only approach since ForceOpen is not implemented
-	cb.stateMachine.TransitionToOpen()
+	cb.ForceOpen()
 	
 	// Circuit should be open
 	if cb.State() != Open {
@@ -523,17 +565,19 @@ func TestCircuitBreakerForceOpen(t *testing.T) {
 	if !IsCircuitOpen(err) {
 		t.Errorf("Execute should return ErrCircuitOpen when circuit is forced open, got: %v", err)
 	}
+	var circuitErr *CircuitError
+	if !errors.As(err, &circuitErr) || circuitErr.Reason != ReasonForcedOpen {
+		t.Errorf("Execute should return a CircuitError with ReasonForcedOpen, got: %v", err)
+	}
 	
-	// Wait for timeout - circuit should remain open because it was forced
-	time.Sleep(1500 * time.Millisecond)
+	// Circuit should remain open after the timeout because it was forced
+	clk.Advance(1500 * time.Millisecond)
 	
 	if cb.State() != Open {
 		t.Errorf("Circuit should remain open after timeout when forced, got %v", cb.State())
 	}
 	
-	// Manually transition the circuit back to closed state
-	// since there's no Reset method in the current implementation
-	cb.stateMachine.TransitionToClosed()
+	cb.Reset()
 	
 	if cb.State() != Closed {
 		t.Errorf("Circuit should be closed after reset, got %v", cb.State())
@@ -541,7 +585,6 @@ func TestCircuitBreakerForceOpen(t *testing.T) {
 }
 
 func TestCircuitBreakerForceClosed(t *testing.T) {
-	t.Skip("ForceClosed method doesn't exist in the current implementation")
 	// Create a circuit breaker
 	settings := Settings{
 		Name:             "TestBreaker",
@@ -565,9 +608,7 @@ func TestCircuitBreakerForceClosed(t *testing.T) {
 		t.Errorf("Circuit should be open, got %v", cb.State())
 	}
 	
-	// Force the circuit closed by directly accessing the state machine
-	// Note: This is a test-only approach since ForceClosed is not implemented
-	cb.stateMachine.TransitionToClosed()
+	cb.ForceClosed()
 	
 	// Circuit should be closed
 	if cb.State() != Closed {
@@ -588,9 +629,7 @@ func TestCircuitBreakerForceClosed(t *testing.T) {
 		t.Errorf("Circuit should remain closed after failures when forced, got %v", cb.State())
 	}
 	
-	// Manually transition the circuit back to normal operation
-	// since there's no Reset method in the current implementation
-	cb.stateMachine.TransitionToClosed()
+	cb.Reset()
 	
 	// Now failures should trip the circuit
 	cb.Execute(func() error {
@@ -689,3 +728,807 @@ func TestCircuitBreakerWithIgnoredErrors(t *testing.T) {
 		t.Errorf("Circuit should be open after non-ignored errors, got %v", cb.State())
 	}
 }
+
+func TestCircuitBreakerResetTimeout(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb, err := New("TestBreaker",
+		WithConsecutiveFailures(3),
+		WithResetTimeout(time.Second),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got error: %v", err)
+	}
+	defer cb.Close()
+
+	// Two failures stay below the threshold
+	for i := 0; i < 2; i++ {
+		cb.Execute(func() error {
+			return errors.New("failure")
+		})
+	}
+
+	if cb.GetMetrics().ConsecutiveFailures != 2 {
+		t.Fatalf("Should have 2 consecutive failures, got %d", cb.GetMetrics().ConsecutiveFailures)
+	}
+
+	// The reset timer clears the counters once the reset timeout elapses
+	clk.Advance(time.Second)
+
+	if cb.GetMetrics().ConsecutiveFailures != 0 {
+		t.Errorf("Consecutive failures should be reset, got %d", cb.GetMetrics().ConsecutiveFailures)
+	}
+
+	// A further failure must not trip the circuit
+	cb.Execute(func() error {
+		return errors.New("failure")
+	})
+
+	if cb.State() != Closed {
+		t.Errorf("Circuit should remain closed after reset, got %v", cb.State())
+	}
+}
+
+func TestCircuitBreakerLazyTransitions(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		name := "Timers"
+		if lazy {
+			name = "Lazy"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			clk := clocktest.NewFakeClock(time.Now())
+			cb := NewCircuitBreaker(Settings{
+				Name:             "TestBreaker",
+				FailureThreshold: ConsecutiveFailures(2),
+				SuccessThreshold: 1,
+				Timeout:          time.Second,
+				ResetTimeout:     500 * time.Millisecond,
+				Clock:            clk,
+				LazyTransitions:  lazy,
+			})
+			defer cb.Close()
+
+			if lazy && clk.PendingTimers() != 0 {
+				t.Errorf("Lazy mode should not schedule timers, got %d", clk.PendingTimers())
+			}
+
+			fail := func() error { return errors.New("failure") }
+			succeed := func() error { return nil }
+
+			// A failure followed by the reset timeout clears the counters
+			cb.Execute(fail)
+			clk.Advance(500 * time.Millisecond)
+			cb.Execute(fail)
+			if cb.State() != Closed {
+				t.Fatalf("Circuit should be closed after reset timeout, got %v", cb.State())
+			}
+
+			// Trip the circuit
+			cb.Execute(fail)
+			if cb.State() != Open {
+				t.Fatalf("Circuit should be open, got %v", cb.State())
+			}
+
+			// Still open just before the timeout
+			clk.Advance(999 * time.Millisecond)
+			if cb.State() != Open {
+				t.Errorf("Circuit should still be open before the timeout, got %v", cb.State())
+			}
+
+			// Half-open once the timeout elapses
+			clk.Advance(time.Millisecond)
+			if cb.State() != HalfOpen {
+				t.Errorf("Circuit should be half-open after the timeout, got %v", cb.State())
+			}
+
+			// A success closes the circuit again
+			if err := cb.Execute(succeed); err != nil {
+				t.Errorf("Execute should succeed in half-open state, got error: %v", err)
+			}
+			if cb.State() != Closed {
+				t.Errorf("Circuit should be closed after success, got %v", cb.State())
+			}
+
+			if lazy && clk.PendingTimers() != 0 {
+				t.Errorf("Lazy mode should not schedule timers, got %d", clk.PendingTimers())
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerLazyTransitionOnExecute(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb := NewCircuitBreaker(Settings{
+		Name:             "TestBreaker",
+		FailureThreshold: ConsecutiveFailures(1),
+		SuccessThreshold: 1,
+		Timeout:          time.Second,
+		Clock:            clk,
+		LazyTransitions:  true,
+	})
+
+	cb.Execute(func() error { return errors.New("failure") })
+	clk.Advance(time.Second)
+
+	// The first call after the deadline is admitted as the half-open probe
+	called := false
+	err := cb.Execute(func() error {
+		called = true
+		return nil
+	})
+
+	if err != nil || !called {
+		t.Errorf("Execute should run the operation after the timeout, got error: %v", err)
+	}
+	if cb.State() != Closed {
+		t.Errorf("Circuit should be closed after the probe succeeded, got %v", cb.State())
+	}
+}
+
+func TestCircuitBreakerLazyDeadlineAfterReset(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb := NewCircuitBreaker(Settings{
+		Name:             "TestBreaker",
+		FailureThreshold: ConsecutiveFailures(1),
+		Timeout:          time.Second,
+		Clock:            clk,
+		LazyTransitions:  true,
+	})
+
+	cb.Execute(func() error { return errors.New("failure") })
+	deadline := cb.openDeadline.Load()
+
+	// Reset closes the circuit after the Open deadline was claimed, which is
+	// simulated by restoring it
+	cb.Reset()
+	cb.openDeadline.Store(deadline)
+	clk.Advance(time.Second)
+
+	if cb.State() != Closed {
+		t.Errorf("Deadline of an earlier Open state should not move a Closed circuit, got %v", cb.State())
+	}
+}
+
+func TestCircuitBreakerLazyResetDuringProbe(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb := NewCircuitBreaker(Settings{
+		Name:             "TestBreaker",
+		FailureThreshold: ConsecutiveFailures(1),
+		SuccessThreshold: 1,
+		Timeout:          time.Second,
+		ResetTimeout:     1500 * time.Millisecond,
+		Clock:            clk,
+		LazyTransitions:  true,
+	})
+
+	cb.Execute(func() error { return errors.New("failure") })
+	clk.Advance(1200 * time.Millisecond)
+
+	// The reset deadline set while Closed expires during the half-open probe,
+	// which must be able to query the breaker without deadlocking
+	done := make(chan State, 1)
+	go func() {
+		cb.Execute(func() error {
+			clk.Advance(time.Second)
+			done <- cb.State()
+			return nil
+		})
+	}()
+
+	select {
+	case state := <-done:
+		if state != HalfOpen {
+			t.Errorf("Circuit should be half-open during the probe, got %v", state)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("State should not block during the half-open probe")
+	}
+
+	waitFor(t, func() bool { return cb.State() == Closed })
+}
+
+func TestCircuitBreakerStaleResults(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb := NewCircuitBreaker(Settings{
+		Name:             "TestBreaker",
+		FailureThreshold: ConsecutiveFailures(2),
+		SuccessThreshold: 1,
+		Timeout:          time.Second,
+		Clock:            clk,
+	})
+	defer cb.Close()
+
+	// Admit a long-running call while the circuit is closed
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- cb.Execute(func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	// Trip the circuit and move it to half-open while the call is in flight
+	for i := 0; i < 2; i++ {
+		cb.Execute(func() error {
+			return errors.New("failure")
+		})
+	}
+	clk.Advance(time.Second)
+	if cb.State() != HalfOpen {
+		t.Fatalf("Circuit should be half-open, got %v", cb.State())
+	}
+
+	// The late success belongs to the Closed generation and must not close the circuit
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Long-running call should return its own result, got error: %v", err)
+	}
+
+	if cb.State() != HalfOpen {
+		t.Errorf("Stale success should not close the circuit, got %v", cb.State())
+	}
+
+	metrics := cb.GetMetrics()
+	if metrics.StaleResults != 1 {
+		t.Errorf("Metrics should show 1 stale result, got %d", metrics.StaleResults)
+	}
+	if metrics.ConsecutiveSuccesses != 0 {
+		t.Errorf("Stale success should not be counted, got %d consecutive successes", metrics.ConsecutiveSuccesses)
+	}
+}
+
+func TestCircuitBreakerStaleSuccessAfterCheck(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb := NewCircuitBreaker(Settings{
+		Name:             "TestBreaker",
+		FailureThreshold: ConsecutiveFailures(1),
+		SuccessThreshold: 2,
+		Timeout:          time.Second,
+		Clock:            clk,
+	})
+	defer cb.Close()
+
+	closedGeneration := cb.stateMachine.Generation()
+	cb.Execute(func() error { return errors.New("failure") })
+	clk.Advance(time.Second)
+	if cb.State() != HalfOpen {
+		t.Fatalf("Circuit should be half-open, got %v", cb.State())
+	}
+
+	// A success admitted while Closed that passed the staleness check just
+	// before the trip is recorded once the circuit is already half-open
+	cb.recordSuccess(closedGeneration)
+
+	cb.Execute(func() error { return nil })
+	if cb.State() != HalfOpen {
+		t.Errorf("Stale success should not count towards the success threshold, got %v", cb.State())
+	}
+
+	cb.Execute(func() error { return nil })
+	if cb.State() != Closed {
+		t.Errorf("Circuit should close after 2 probe successes, got %v", cb.State())
+	}
+}
+
+func TestCircuitBreakerQueuedProbeAfterTrip(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb := NewCircuitBreaker(Settings{
+		Name:             "TestBreaker",
+		FailureThreshold: ConsecutiveFailures(1),
+		Timeout:          time.Second,
+		Clock:            clk,
+	})
+	defer cb.Close()
+
+	cb.Execute(func() error { return errors.New("failure") })
+	clk.Advance(time.Second)
+
+	// The first probe holds the Half-Open slot while a second one waits for it
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go cb.Execute(func() error {
+		close(started)
+		<-release
+		return errors.New("failure")
+	})
+	<-started
+
+	queued := make(chan error)
+	called := false
+	go func() {
+		queued <- cb.Execute(func() error {
+			called = true
+			return nil
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// The first probe fails and reopens the circuit, so the queued one is rejected
+	close(release)
+	err := <-queued
+	if called {
+		t.Error("Queued probe should not run once the circuit reopened")
+	}
+	var circuitErr *CircuitError
+	if !errors.As(err, &circuitErr) || circuitErr.State != Open || circuitErr.Reason != ReasonOpen {
+		t.Errorf("Queued probe should be rejected as Open, got %v", err)
+	}
+}
+
+func TestCircuitBreakerCountWindow(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb, err := New("TestBreaker",
+		WithCountWindow(0.5, 20, 20),
+		WithTimeout(time.Hour),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got error: %v", err)
+	}
+	defer cb.Close()
+
+	// Spread 19 calls, 10 of them failures, over many hours. A time window
+	// would have forgotten the early failures; the count window does not.
+	for i := 0; i < 19; i++ {
+		cb.Execute(func() error {
+			if i%2 == 0 {
+				return errors.New("failure")
+			}
+			return nil
+		})
+		clk.Advance(time.Hour)
+	}
+
+	if cb.State() != Closed {
+		t.Fatalf("Circuit should stay closed below the minimum request volume, got %v", cb.State())
+	}
+
+	// The 20th call reaches the volume with 11 of 20 failed
+	cb.Execute(func() error {
+		return errors.New("failure")
+	})
+
+	if cb.State() != Open {
+		t.Errorf("Circuit should trip at 55%% failures over the last 20 calls, got %v", cb.State())
+	}
+
+	metrics := cb.GetMetrics()
+	if metrics.TotalRequests != 20 || metrics.TotalFailures != 11 {
+		t.Errorf("Metrics should show 20 requests and 11 failures, got %d requests and %d failures",
+			metrics.TotalRequests, metrics.TotalFailures)
+	}
+}
+
+func TestCircuitBreakerLatencyMetrics(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb, err := New("TestBreaker",
+		WithLatencyWindow(10*time.Second, 10),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got error: %v", err)
+	}
+	defer cb.Close()
+
+	// Calls take 1ms to 100ms; failures are timed as well
+	for i := 1; i <= 100; i++ {
+		cb.Execute(func() error {
+			clk.Advance(time.Duration(i) * time.Millisecond)
+			if i%10 == 0 {
+				return errors.New("failure")
+			}
+			return nil
+		})
+	}
+
+	metrics := cb.GetMetrics()
+	if metrics.LatencySamples != 100 {
+		t.Errorf("Metrics should show 100 latency samples, got %d", metrics.LatencySamples)
+	}
+
+	checks := []struct {
+		name string
+		got  time.Duration
+		want time.Duration
+	}{
+		{"p50", metrics.LatencyP50, 50 * time.Millisecond},
+		{"p95", metrics.LatencyP95, 95 * time.Millisecond},
+		{"p99", metrics.LatencyP99, 99 * time.Millisecond},
+	}
+	for _, c := range checks {
+		if diff := c.got - c.want; diff > c.want/8 || -diff > c.want/8 {
+			t.Errorf("%s should be close to %v, got %v", c.name, c.want, c.got)
+		}
+	}
+
+	// Samples expire with the latency window
+	clk.Advance(20 * time.Second)
+	if metrics := cb.GetMetrics(); metrics.LatencySamples != 0 || metrics.LatencyP99 != 0 {
+		t.Errorf("Latency should expire with the window, got %d samples and p99 %v",
+			metrics.LatencySamples, metrics.LatencyP99)
+	}
+}
+
+func TestCircuitBreakerLatencyDisabled(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{
+		Name:             "TestBreaker",
+		FailureThreshold: ConsecutiveFailures(3),
+		Timeout:          time.Second,
+	})
+	defer cb.Close()
+
+	cb.Execute(func() error { return nil })
+
+	if metrics := cb.GetMetrics(); metrics.LatencySamples != 0 {
+		t.Errorf("Latency should not be recorded without a latency window, got %d samples", metrics.LatencySamples)
+	}
+}
+
+func TestCircuitBreakerCallTimeout(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb, err := New("TestBreaker",
+		WithConsecutiveFailures(3),
+		WithCallTimeout(time.Second),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	// A call that respects its context returns once the timeout expires
+	err = cb.ExecuteContext(context.Background(), func(ctx context.Context) error {
+		clk.Advance(2 * time.Second)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, ErrCallTimeout) {
+		t.Errorf("Should return ErrCallTimeout, got %v", err)
+	}
+
+	// A call that finishes late is a timeout even if it succeeded
+	err = cb.Execute(func() error {
+		clk.Advance(2 * time.Second)
+		return nil
+	})
+	if err != ErrCallTimeout {
+		t.Errorf("A late success should return ErrCallTimeout, got %v", err)
+	}
+
+	// The operation's own error is kept alongside the timeout
+	opErr := errors.New("connection reset")
+	err = cb.Execute(func() error {
+		clk.Advance(2 * time.Second)
+		return opErr
+	})
+	if !errors.Is(err, ErrCallTimeout) || !errors.Is(err, opErr) {
+		t.Errorf("Should wrap both the timeout and the operation's error, got %v", err)
+	}
+
+	if cb.State() != Open {
+		t.Errorf("Timeouts should count as failures, got %v", cb.State())
+	}
+	if outcomes := cb.GetMetrics().Outcomes; outcomes["CallTimeout"] != 3 {
+		t.Errorf("Timeouts should be reported as CallTimeout outcomes, got %v", outcomes)
+	}
+	if clk.PendingTimers() != 1 {
+		t.Errorf("Call timeout timers should be stopped, got %d pending timers", clk.PendingTimers())
+	}
+}
+
+func TestCircuitBreakerCallTimeoutCallerCancellation(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb, err := New("TestBreaker",
+		WithConsecutiveFailures(1),
+		WithCallTimeout(time.Second),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	// Calls that finish in time are unaffected
+	if err := cb.Execute(func() error { return nil }); err != nil {
+		t.Fatalf("A quick call should succeed, got %v", err)
+	}
+
+	// The caller giving up first is not a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	err = cb.ExecuteContext(ctx, func(ctx context.Context) error {
+		cancel()
+		clk.Advance(2 * time.Second)
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrCallTimeout) {
+		t.Errorf("Should return the caller's cancellation, got %v", err)
+	}
+	if cb.State() != Closed {
+		t.Errorf("A caller cancellation should not trip the circuit, got %v", cb.State())
+	}
+}
+
+func TestCircuitBreakerAbandonOnTimeout(t *testing.T) {
+	cb, err := New("TestBreaker",
+		WithCallTimeout(10*time.Millisecond),
+		WithAbandonOnTimeout(),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	// The operation ignores its context
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	err = cb.Execute(func() error {
+		defer close(finished)
+		<-release
+		return nil
+	})
+	if !errors.Is(err, ErrCallTimeout) {
+		t.Fatalf("Should return ErrCallTimeout without waiting, got %v", err)
+	}
+	if abandoned := cb.GetMetrics().AbandonedCalls; abandoned != 1 {
+		t.Errorf("One abandoned call should still be running, got %d", abandoned)
+	}
+
+	close(release)
+	<-finished
+	deadline := time.Now().Add(time.Second)
+	for cb.GetMetrics().AbandonedCalls != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if abandoned := cb.GetMetrics().AbandonedCalls; abandoned != 0 {
+		t.Errorf("Finished calls should no longer be counted as abandoned, got %d", abandoned)
+	}
+
+	// Calls that finish in time return their result
+	if err := cb.Execute(func() error { return nil }); err != nil {
+		t.Errorf("A quick call should succeed, got %v", err)
+	}
+}
+
+func TestCircuitBreakerPanicPropagate(t *testing.T) {
+	cb, err := New("TestBreaker", WithConsecutiveFailures(1))
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	var panicked *PanicError
+	cb.OnPanic(func(name string, err *PanicError) {
+		panicked = err
+	})
+
+	func() {
+		defer func() {
+			if v := recover(); v != "boom" {
+				t.Errorf("Should panic again with the original value, got %v", v)
+			}
+		}()
+		cb.Execute(func() error {
+			panic("boom")
+		})
+	}()
+
+	if panicked == nil || panicked.Value != "boom" || len(panicked.Stack) == 0 {
+		t.Fatalf("Panic callback should receive the value and stack, got %+v", panicked)
+	}
+	if cb.State() != Open {
+		t.Errorf("A panic should be recorded as a failure, got %v", cb.State())
+	}
+	if outcomes := cb.GetMetrics().Outcomes; outcomes["Panic"] != 1 {
+		t.Errorf("The panic should be reported as a Panic outcome, got %v", outcomes)
+	}
+}
+
+func TestCircuitBreakerPanicReturnError(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb, err := New("TestBreaker",
+		WithConsecutiveFailures(1),
+		WithTimeout(time.Second),
+		WithPanicPolicy(PanicReturnError),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	cause := errors.New("nil map")
+	err = cb.Execute(func() error {
+		panic(cause)
+	})
+
+	var pe *PanicError
+	if !errors.As(err, &pe) || !errors.Is(err, cause) {
+		t.Fatalf("Should return a *PanicError wrapping the panic value, got %v", err)
+	}
+	if !strings.Contains(string(pe.Stack), "TestCircuitBreakerPanicReturnError") {
+		t.Errorf("Stack should include the panicking function, got %s", pe.Stack)
+	}
+
+	// A panicking Half-Open probe reopens the circuit and releases the probe lock
+	clk.Advance(time.Second)
+	err = cb.Execute(func() error {
+		panic("probe")
+	})
+	if !errors.As(err, &pe) || cb.State() != Open {
+		t.Fatalf("A panicking probe should reopen the circuit, got %v in state %v", err, cb.State())
+	}
+
+	clk.Advance(time.Second)
+	if err := cb.Execute(func() error { return nil }); err != nil {
+		t.Errorf("The next probe should run, got %v", err)
+	}
+	if cb.State() != Closed {
+		t.Errorf("A successful probe should close the circuit, got %v", cb.State())
+	}
+
+	settings := DefaultSettings()
+	settings.PanicPolicy = PanicPolicy(5)
+	if err := settings.Validate(); !errors.Is(err, ErrInvalidSettings) {
+		t.Errorf("An unknown panic policy should be invalid, got %v", err)
+	}
+	if PanicPropagate.String() != "Propagate" || PanicReturnError.String() != "ReturnError" {
+		t.Errorf("Unexpected policy names: %s, %s", PanicPropagate, PanicReturnError)
+	}
+}
+
+func TestCircuitBreakerRejectionError(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	cb := NewCircuitBreaker(Settings{
+		Name:             "TestBreaker",
+		FailureThreshold: ConsecutiveFailures(1),
+		SuccessThreshold: 1,
+		Timeout:          10 * time.Second,
+		Clock:            clk,
+	})
+
+	tripErr := errors.New("dependency down")
+	cb.Execute(func() error { return tripErr })
+
+	clk.Advance(4 * time.Second)
+	err := cb.Execute(func() error {
+		t.Error("Request should not be executed while the circuit is open")
+		return nil
+	})
+
+	var circuitErr *CircuitError
+	if !errors.As(err, &circuitErr) {
+		t.Fatalf("Execute should return a CircuitError, got %v", err)
+	}
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("CircuitError should match ErrCircuitOpen")
+	}
+	if circuitErr.Name != "TestBreaker" || circuitErr.State != Open || circuitErr.Reason != ReasonOpen {
+		t.Errorf("Unexpected CircuitError %+v", circuitErr)
+	}
+	if circuitErr.RetryAfter != 6*time.Second {
+		t.Errorf("RetryAfter should be 6s, got %v", circuitErr.RetryAfter)
+	}
+	if circuitErr.LastTripErr != tripErr {
+		t.Errorf("LastTripErr should be the error that tripped the circuit, got %v", circuitErr.LastTripErr)
+	}
+	if errors.Is(err, tripErr) {
+		t.Errorf("CircuitError should not match the error that tripped the circuit")
+	}
+}
+
+func TestCircuitBreakerHalfOpenMaxRequests(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	cb := NewCircuitBreaker(Settings{
+		Name:                "TestBreaker",
+		FailureThreshold:    ConsecutiveFailures(1),
+		SuccessThreshold:    3,
+		HalfOpenMaxRequests: 2,
+		Timeout:             time.Second,
+		Clock:               clk,
+	})
+
+	cb.Execute(func() error { return errors.New("failure") })
+	clk.Advance(time.Second)
+	if cb.State() != HalfOpen {
+		t.Fatalf("Circuit should be half-open, got %v", cb.State())
+	}
+
+	// Hold two probes in flight
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cb.Execute(func() error {
+				started <- struct{}{}
+				<-release
+				return nil
+			})
+		}()
+	}
+	<-started
+	<-started
+
+	err := cb.Execute(func() error {
+		t.Error("Request should not be executed beyond HalfOpenMaxRequests")
+		return nil
+	})
+	var circuitErr *CircuitError
+	if !errors.As(err, &circuitErr) || circuitErr.Reason != ReasonHalfOpenFull || circuitErr.State != HalfOpen {
+		t.Errorf("Execute should be rejected with ReasonHalfOpenFull, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+
+	// The probes are done, so another one is admitted and closes the circuit
+	if err := cb.Execute(func() error { return nil }); err != nil {
+		t.Errorf("Probe should be admitted once others finished, got %v", err)
+	}
+	if cb.State() != Closed {
+		t.Errorf("Circuit should be closed after SuccessThreshold probes, got %v", cb.State())
+	}
+}
+
+func TestCircuitBreakerExecuteWithFallbackSkipsNonFailures(t *testing.T) {
+	notFound := errors.New("not found")
+	cb, err := New("TestBreaker", WithConsecutiveFailures(1), WithIgnoredErrors(notFound))
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	fallbacks := 0
+	fallback := func(err error) error {
+		fallbacks++
+		return nil
+	}
+
+	// Ignored errors are returned without falling back
+	if err := cb.ExecuteWithFallback(func() error { return notFound }, fallback); err != notFound || fallbacks != 0 {
+		t.Errorf("Ignored error should be returned as is, got %v after %d fallbacks", err, fallbacks)
+	}
+
+	// Failures and rejections fall back
+	cb.ExecuteWithFallback(func() error { return errors.New("failure") }, fallback)
+	cb.ExecuteWithFallback(func() error { return nil }, fallback)
+	if fallbacks != 2 {
+		t.Errorf("Failure and rejection should fall back, got %d fallbacks", fallbacks)
+	}
+}
+
+func TestCircuitBreakerExecuteWithFallbackClassifiesOnce(t *testing.T) {
+	classified := 0
+	cb := NewCircuitBreaker(Settings{
+		Name:             "TestBreaker",
+		FailureThreshold: ConsecutiveFailures(5),
+		Timeout:          time.Hour,
+		Classify: func(err error) Outcome {
+			classified++
+			if err != nil {
+				return Outcome{Name: "Failure", Kind: KindFailure}
+			}
+			return Success
+		},
+	})
+	defer cb.Close()
+
+	err := cb.ExecuteWithFallback(func() error {
+		return errors.New("failure")
+	}, func(err error) error {
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Fallback should recover from the failure, got %v", err)
+	}
+	if classified != 1 {
+		t.Errorf("Call should be classified once, got %d", classified)
+	}
+}
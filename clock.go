@@ -0,0 +1,19 @@
+package gomian
+
+import (
+	"github.com/nutcase/gomian/internal/clock"
+)
+
+// Clock abstracts the passage of time for a circuit breaker. The breaker, its
+// counters and its state machine read the time and schedule timers only
+// through the configured Clock, which allows tests to control time with
+// clocktest.FakeClock instead of sleeping.
+type Clock = clock.Clock
+
+// Timer is a handle to a function scheduled with Clock.AfterFunc.
+type Timer = clock.Timer
+
+// RealClock returns a Clock backed by the time package.
+func RealClock() Clock {
+	return clock.Real()
+}
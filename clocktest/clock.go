@@ -0,0 +1,130 @@
+// Package clocktest provides a manually driven clock for deterministic tests
+// of circuit breakers.
+package clocktest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nutcase/gomian/internal/clock"
+)
+
+// FakeClock is a clock whose time only moves when Advance is called.
+// Functions scheduled with AfterFunc run synchronously, on the goroutine
+// calling Advance, once their deadline has been reached.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	nextID uint64
+}
+
+// fakeTimer is a function scheduled on a FakeClock.
+type fakeTimer struct {
+	clock    *FakeClock
+	id       uint64
+	deadline time.Time
+	f        func()
+}
+
+// NewFakeClock creates a FakeClock set to the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Since returns the fake time elapsed since t.
+func (c *FakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// AfterFunc schedules f to run once the clock has been advanced by d.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) clock.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	t := &fakeTimer{
+		clock:    c,
+		id:       c.nextID,
+		deadline: c.now.Add(d),
+		f:        f,
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, running every timer whose deadline
+// falls within that interval in deadline order. Timers scheduled by those
+// functions are run as well if they fall due before the new time.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		t := c.popDue(target)
+		if t == nil {
+			c.now = target
+			c.mu.Unlock()
+			return
+		}
+		c.now = t.deadline
+		c.mu.Unlock()
+
+		t.f()
+	}
+}
+
+// PendingTimers returns the number of timers that have not yet fired or been stopped.
+func (c *FakeClock) PendingTimers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// popDue removes and returns the earliest timer due at or before target.
+// Timers with equal deadlines fire in the order they were scheduled.
+func (c *FakeClock) popDue(target time.Time) *fakeTimer {
+	if len(c.timers) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		if c.timers[i].deadline.Equal(c.timers[j].deadline) {
+			return c.timers[i].id < c.timers[j].id
+		}
+		return c.timers[i].deadline.Before(c.timers[j].deadline)
+	})
+
+	t := c.timers[0]
+	if t.deadline.After(target) {
+		return nil
+	}
+	c.timers = c.timers[1:]
+	return t
+}
+
+// Stop removes the timer from its clock. It returns false if the timer
+// has already fired or been stopped.
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package clocktest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClockAdvance(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+
+	if !c.Now().Equal(start) {
+		t.Errorf("Now should be %v, got %v", start, c.Now())
+	}
+
+	c.Advance(5 * time.Second)
+	if c.Since(start) != 5*time.Second {
+		t.Errorf("Since should be 5s, got %v", c.Since(start))
+	}
+}
+
+func TestFakeClockAfterFunc(t *testing.T) {
+	c := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	var fired []string
+	c.AfterFunc(2*time.Second, func() {
+		fired = append(fired, "second")
+	})
+	c.AfterFunc(time.Second, func() {
+		fired = append(fired, "first")
+	})
+
+	c.Advance(500 * time.Millisecond)
+	if len(fired) != 0 {
+		t.Errorf("No timer should fire before its deadline, got %v", fired)
+	}
+
+	c.Advance(2 * time.Second)
+	if len(fired) != 2 || fired[0] != "first" || fired[1] != "second" {
+		t.Errorf("Timers should fire in deadline order, got %v", fired)
+	}
+	if c.PendingTimers() != 0 {
+		t.Errorf("No timers should be pending, got %d", c.PendingTimers())
+	}
+}
+
+func TestFakeClockTimerSeesDeadline(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+
+	var seen time.Time
+	c.AfterFunc(time.Second, func() {
+		seen = c.Now()
+	})
+
+	c.Advance(time.Minute)
+	if !seen.Equal(start.Add(time.Second)) {
+		t.Errorf("Timer should observe its deadline as now, got %v", seen)
+	}
+	if !c.Now().Equal(start.Add(time.Minute)) {
+		t.Errorf("Clock should end at the target time, got %v", c.Now())
+	}
+}
+
+func TestFakeClockNestedTimers(t *testing.T) {
+	c := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	count := 0
+	var schedule func()
+	schedule = func() {
+		c.AfterFunc(time.Second, func() {
+			count++
+			schedule()
+		})
+	}
+	schedule()
+
+	c.Advance(3 * time.Second)
+	if count != 3 {
+		t.Errorf("Rescheduled timer should fire 3 times, got %d", count)
+	}
+	if c.PendingTimers() != 1 {
+		t.Errorf("One timer should remain pending, got %d", c.PendingTimers())
+	}
+}
+
+func TestFakeClockStop(t *testing.T) {
+	c := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	timer := c.AfterFunc(time.Second, func() {
+		t.Error("Stopped timer should not fire")
+	})
+
+	if !timer.Stop() {
+		t.Error("Stop should return true for a pending timer")
+	}
+	if timer.Stop() {
+		t.Error("Stop should return false for an already stopped timer")
+	}
+
+	c.Advance(2 * time.Second)
+}
@@ -3,21 +3,95 @@ package gomian
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 var (
 	// ErrCircuitOpen is returned when a request is rejected because the circuit is open.
 	ErrCircuitOpen = errors.New("circuit breaker is open")
+
+	// ErrRampUpRejected is returned when a request is rejected because the
+	// circuit has only just closed and is still ramping up traffic.
+	ErrRampUpRejected = errors.New("circuit breaker is ramping up")
+
+	// ErrCallTimeout is returned when a call overruns Settings.CallTimeout.
+	ErrCallTimeout = errors.New("circuit breaker call timed out")
+
+	// ErrBulkheadFull is returned when a Bulkhead rejects a call because all
+	// of its slots are taken and its queue is full or timed out.
+	ErrBulkheadFull = errors.New("bulkhead is full")
+
+	// ErrThrottled is returned when an AdaptiveThrottle rejects a request locally.
+	ErrThrottled = errors.New("request throttled")
+
+	// ErrNoResult is returned by Do when a stage such as a Fallback recovered
+	// from a failed call, so that the pipeline succeeded without a result.
+	ErrNoResult = errors.New("pipeline call recovered without a result")
+
+	// ErrInvalidSettings is wrapped by every error returned from Settings.Validate.
+	ErrInvalidSettings = errors.New("invalid circuit breaker settings")
+)
+
+// RejectionReason tells why a circuit breaker rejected a request.
+type RejectionReason int
+
+const (
+	// ReasonOpen means the circuit is Open.
+	ReasonOpen RejectionReason = iota
+
+	// ReasonHalfOpenFull means the circuit is Half-Open and already has
+	// Settings.HalfOpenMaxRequests probe requests in flight.
+	ReasonHalfOpenFull
+
+	// ReasonRampUp means the circuit has only just closed and the request
+	// was not admitted during the ramp-up phase.
+	ReasonRampUp
+
+	// ReasonForcedOpen means the circuit was forced Open with ForceOpen.
+	ReasonForcedOpen
 )
 
+// String returns a string representation of the RejectionReason.
+func (r RejectionReason) String() string {
+	switch r {
+	case ReasonOpen:
+		return "Open"
+	case ReasonHalfOpenFull:
+		return "HalfOpenFull"
+	case ReasonRampUp:
+		return "RampUp"
+	case ReasonForcedOpen:
+		return "ForcedOpen"
+	default:
+		return fmt.Sprintf("Unknown RejectionReason(%d)", r)
+	}
+}
+
 // CircuitError represents an error that occurred within the circuit breaker.
+// Requests rejected by a circuit breaker return a *CircuitError describing
+// the rejection, which matches ErrCircuitOpen with errors.Is whatever its reason.
 type CircuitError struct {
 	Name string
 	Err  error
+
+	// State is the state of the circuit when the request was rejected.
+	State State
+	// Reason tells why the request was rejected.
+	Reason RejectionReason
+	// RetryAfter is the time left until the circuit lets a probe request
+	// through. It is zero if unknown, e.g. when the circuit was forced Open.
+	RetryAfter time.Duration
+	// LastTripErr is the error of the call that last tripped the circuit.
+	// It is nil if the circuit was tripped by a successful call, e.g. by a
+	// latency threshold, or has never tripped.
+	LastTripErr error
 }
 
 // Error returns a string representation of the CircuitError.
 func (e *CircuitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("circuit breaker '%s': %v (retry after %v)", e.Name, e.Err, e.RetryAfter)
+	}
 	return fmt.Sprintf("circuit breaker '%s': %v", e.Name, e.Err)
 }
 
@@ -26,7 +100,36 @@ func (e *CircuitError) Unwrap() error {
 	return e.Err
 }
 
-// IsCircuitOpen checks if the error is or wraps an ErrCircuitOpen error.
+// Is reports whether target is ErrCircuitOpen, so that every rejection
+// matches it, including those wrapping ErrRampUpRejected.
+func (e *CircuitError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// PanicError is returned by ExecuteContext when the operation panicked and
+// Settings.PanicPolicy is PanicReturnError.
+type PanicError struct {
+	// Value is the value the operation panicked with.
+	Value any
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+// Error returns a string representation of the PanicError.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// IsCircuitOpen checks if the error is or wraps an ErrCircuitOpen error,
+// which includes every *CircuitError.
 func IsCircuitOpen(err error) bool {
 	return errors.Is(err, ErrCircuitOpen)
 }
@@ -3,6 +3,7 @@ package gomian
 import (
 	"errors"
 	"testing"
+	"time"
 )
 
 func TestErrCircuitOpen(t *testing.T) {
@@ -115,3 +116,48 @@ func TestUnwrap(t *testing.T) {
 		t.Error("errors.Unwrap should return nil for CircuitError with nil error")
 	}
 }
+
+func TestPanicError(t *testing.T) {
+	err := &PanicError{Value: "boom", Stack: []byte("stack")}
+	if err.Error() != "panic: boom" {
+		t.Errorf("Error message should be 'panic: boom', got '%s'", err.Error())
+	}
+	if err.Unwrap() != nil {
+		t.Errorf("A non-error panic value should not be unwrapped, got %v", err.Unwrap())
+	}
+
+	cause := errors.New("cause")
+	err = &PanicError{Value: cause}
+	if !errors.Is(err, cause) {
+		t.Error("An error panic value should be unwrapped")
+	}
+}
+
+func TestCircuitErrorRejection(t *testing.T) {
+	err := &CircuitError{
+		Name:   "TestBreaker",
+		Err:    ErrRampUpRejected,
+		State:  Closed,
+		Reason: ReasonRampUp,
+	}
+	if !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, ErrRampUpRejected) {
+		t.Error("CircuitError should match both ErrCircuitOpen and the error it wraps")
+	}
+
+	err = &CircuitError{Name: "TestBreaker", Err: ErrCircuitOpen, RetryAfter: 5 * time.Second}
+	if got, want := err.Error(), "circuit breaker 'TestBreaker': circuit breaker is open (retry after 5s)"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+
+	for reason, want := range map[RejectionReason]string{
+		ReasonOpen:          "Open",
+		ReasonHalfOpenFull:  "HalfOpenFull",
+		ReasonRampUp:        "RampUp",
+		ReasonForcedOpen:    "ForcedOpen",
+		RejectionReason(42): "Unknown RejectionReason(42)",
+	} {
+		if got := reason.String(); got != want {
+			t.Errorf("RejectionReason(%d).String() = %q, want %q", int(reason), got, want)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package gomian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nutcase/gomian/internal/clock"
+)
+
+// errNoCachedValue is returned by the last-known-good fallback when it holds
+// no fresh value for a key.
+var errNoCachedValue = errors.New("no last known good value")
+
+// Fallback is a Policy that handles failed calls with a fallback function,
+// whose error replaces that of the call. It cannot supply a value, so Do
+// returns ErrNoResult when it recovered; use a FallbackChain for that.
+type Fallback struct {
+	name     string
+	fallback func(context.Context, error) error
+}
+
+// NewFallback creates a new Fallback policy calling fallback with the error
+// of each failed call.
+func NewFallback(name string, fallback func(context.Context, error) error) *Fallback {
+	if name == "" {
+		name = "fallback"
+	}
+	return &Fallback{
+		name:     name,
+		fallback: fallback,
+	}
+}
+
+// ExecuteContext executes the given function with context, and the fallback if it fails.
+func (f *Fallback) ExecuteContext(ctx context.Context, op func(context.Context) error) error {
+	err := op(ctx)
+	if err == nil {
+		return nil
+	}
+	return f.fallback(ctx, err)
+}
+
+// Name returns the name of the fallback policy.
+func (f *Fallback) Name() string {
+	return f.name
+}
+
+// FallbackCondition selects the errors a fallback strategy applies to.
+type FallbackCondition int
+
+const (
+	// FallbackOnAny applies a fallback to rejections and failures alike.
+	FallbackOnAny FallbackCondition = iota
+
+	// FallbackOnRejection only applies a fallback to requests rejected
+	// without being executed, by a circuit breaker, bulkhead or throttle.
+	FallbackOnRejection
+
+	// FallbackOnFailure only applies a fallback to executed calls that failed.
+	FallbackOnFailure
+)
+
+// String returns a string representation of the FallbackCondition.
+func (c FallbackCondition) String() string {
+	switch c {
+	case FallbackOnAny:
+		return "Any"
+	case FallbackOnRejection:
+		return "Rejection"
+	case FallbackOnFailure:
+		return "Failure"
+	default:
+		return fmt.Sprintf("Unknown FallbackCondition(%d)", c)
+	}
+}
+
+// applies reports whether a fallback with this condition applies to an error
+// that is a rejection or a failure.
+func (c FallbackCondition) applies(rejected bool) bool {
+	switch c {
+	case FallbackOnRejection:
+		return rejected
+	case FallbackOnFailure:
+		return !rejected
+	default:
+		return true
+	}
+}
+
+// isRejection reports whether err means the request was rejected without
+// being executed.
+func isRejection(err error) bool {
+	return IsCircuitOpen(err) || errors.Is(err, ErrBulkheadFull) || errors.Is(err, ErrThrottled)
+}
+
+// FallbackStrategy is a way of producing a result for a call that could not
+// be made or failed, such as calling a secondary service or returning a
+// default value.
+type FallbackStrategy[T any] struct {
+	// Name identifies the strategy in metrics and callbacks.
+	Name string
+	// When selects the errors the strategy applies to.
+	When FallbackCondition
+	// Fallback returns the result for the call with the given key that failed
+	// with err, or an error if it has none, in which case the next strategy is tried.
+	Fallback func(ctx context.Context, key string, err error) (T, error)
+}
+
+// StaticFallback returns a strategy falling back to a fixed value.
+func StaticFallback[T any](name string, when FallbackCondition, value T) FallbackStrategy[T] {
+	return FallbackStrategy[T]{
+		Name: name,
+		When: when,
+		Fallback: func(ctx context.Context, key string, err error) (T, error) {
+			return value, nil
+		},
+	}
+}
+
+// FallbackChainSettings defines the configuration for a FallbackChain.
+type FallbackChainSettings[T any] struct {
+	// Name is a unique identifier for this fallback chain.
+	Name string
+
+	// Strategies are tried in order until one of them returns a result.
+	Strategies []FallbackStrategy[T]
+
+	// Cache, if set, stores the result of every successful call under its
+	// key. Add its Fallback to Strategies to fall back to these results.
+	Cache *LastKnownGood[T]
+
+	// Breaker, if set, executes every call, and decides which errors that are
+	// not rejections are failures the strategies apply to: those it classifies
+	// as failures. Ignored errors, for instance, are returned as they are.
+	Breaker *CircuitBreaker
+
+	// IsFailure, if set, determines whether an error that is not a rejection
+	// is a failure the strategies apply to, instead of Breaker. Other errors
+	// are returned as they are. If neither is set, every error is a failure.
+	IsFailure func(error) bool
+}
+
+// FallbackCallback is a function that is called when a fallback strategy
+// recovered from a call that failed with err.
+type FallbackCallback func(name, strategy string, err error)
+
+// FallbackMetrics represents the current metrics of a FallbackChain.
+type FallbackMetrics struct {
+	Name       string
+	Calls      uint64
+	Rejections uint64
+	Failures   uint64
+
+	// Recovered counts the calls recovered from by strategy name, and
+	// Unrecovered those no strategy could recover from.
+	Recovered   map[string]uint64
+	Unrecovered uint64
+}
+
+// FallbackChain recovers from rejected or failed calls by trying a list of
+// fallback strategies in order, e.g. a secondary service, then the last known
+// good result, then a static default:
+//
+//	chain := gomian.NewFallbackChain(gomian.FallbackChainSettings[Price]{
+//		Name:  "prices",
+//		Cache: cache,
+//		Strategies: []gomian.FallbackStrategy[Price]{
+//			{Name: "secondary", When: gomian.FallbackOnAny, Fallback: fetchFromSecondary},
+//			cache.Fallback(gomian.FallbackOnAny),
+//			gomian.StaticFallback("default", gomian.FallbackOnRejection, Price{}),
+//		},
+//	})
+type FallbackChain[T any] struct {
+	name        string
+	settings    FallbackChainSettings[T]
+	calls       atomic.Uint64
+	rejections  atomic.Uint64
+	failures    atomic.Uint64
+	unrecovered atomic.Uint64
+	recovered   sync.Map // strategy name -> *atomic.Uint64
+	onFallback  []FallbackCallback
+}
+
+// NewFallbackChain creates a new FallbackChain with the provided settings.
+func NewFallbackChain[T any](settings FallbackChainSettings[T]) *FallbackChain[T] {
+	if settings.Name == "" {
+		settings.Name = "default"
+	}
+
+	return &FallbackChain[T]{
+		name:     settings.Name,
+		settings: settings,
+	}
+}
+
+// Execute executes op with context and returns its result. If op is rejected
+// or fails, the strategies that apply to its error are tried in order, and
+// the result of the first one that succeeds is returned. If none does, op's
+// error is returned. key identifies the request for the cache and strategies.
+// Nothing is tried once ctx is done, since the caller gave up on the call.
+func (c *FallbackChain[T]) Execute(ctx context.Context, key string, op func(context.Context) (T, error)) (T, error) {
+	c.calls.Add(1)
+
+	value, outcome, err := c.execute(ctx, op)
+	if err == nil {
+		if c.settings.Cache != nil {
+			c.settings.Cache.Store(key, value)
+		}
+		return value, nil
+	}
+	if ctx.Err() != nil {
+		return value, err
+	}
+
+	rejected := isRejection(err)
+	switch {
+	case rejected:
+		c.rejections.Add(1)
+	case c.settings.IsFailure != nil && !c.settings.IsFailure(err),
+		c.settings.IsFailure == nil && outcome.Kind != KindFailure:
+		return value, err
+	default:
+		c.failures.Add(1)
+	}
+
+	for _, strategy := range c.settings.Strategies {
+		if !strategy.When.applies(rejected) {
+			continue
+		}
+		if fallbackValue, fallbackErr := strategy.Fallback(ctx, key, err); fallbackErr == nil {
+			c.countRecovered(strategy.Name)
+			for _, callback := range c.onFallback {
+				callback(c.name, strategy.Name, err)
+			}
+			return fallbackValue, nil
+		}
+	}
+
+	c.unrecovered.Add(1)
+	var zero T
+	return zero, err
+}
+
+// execute runs op, through the breaker if one is set, and returns its result
+// together with the outcome the breaker classified it as. Without a breaker,
+// every error is a failure.
+func (c *FallbackChain[T]) execute(ctx context.Context, op func(context.Context) (T, error)) (T, Outcome, error) {
+	if c.settings.Breaker == nil {
+		value, err := op(ctx)
+		return value, defaultOutcome(err, nil, nil), err
+	}
+
+	// An abandoned call may still finish after the breaker returned, so the
+	// result is only taken while the call is in progress
+	var mu sync.Mutex
+	var result T
+	done := false
+
+	outcome, err := c.settings.Breaker.execute(ctx, func(ctx context.Context) error {
+		v, err := op(ctx)
+		mu.Lock()
+		defer mu.Unlock()
+		if !done {
+			result = v
+		}
+		return err
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	done = true
+	if err != nil {
+		var zero T
+		return zero, outcome, err
+	}
+	return result, outcome, nil
+}
+
+// countRecovered increments the number of calls recovered from by the named strategy.
+func (c *FallbackChain[T]) countRecovered(strategy string) {
+	count, ok := c.recovered.Load(strategy)
+	if !ok {
+		count, _ = c.recovered.LoadOrStore(strategy, new(atomic.Uint64))
+	}
+	count.(*atomic.Uint64).Add(1)
+}
+
+// OnFallback registers a callback for calls recovered from by a strategy.
+func (c *FallbackChain[T]) OnFallback(callback FallbackCallback) {
+	c.onFallback = append(c.onFallback, callback)
+}
+
+// Name returns the name of the fallback chain.
+func (c *FallbackChain[T]) Name() string {
+	return c.name
+}
+
+// GetMetrics returns the current metrics of the fallback chain.
+func (c *FallbackChain[T]) GetMetrics() FallbackMetrics {
+	metrics := FallbackMetrics{
+		Name:        c.name,
+		Calls:       c.calls.Load(),
+		Rejections:  c.rejections.Load(),
+		Failures:    c.failures.Load(),
+		Recovered:   make(map[string]uint64),
+		Unrecovered: c.unrecovered.Load(),
+	}
+
+	c.recovered.Range(func(name, count any) bool {
+		metrics.Recovered[name.(string)] = count.(*atomic.Uint64).Load()
+		return true
+	})
+
+	return metrics
+}
+
+// lastKnownGoodEntry is a value held by a LastKnownGood cache.
+type lastKnownGoodEntry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// LastKnownGood caches the last successful result for each request key for
+// a limited time, so that it can be served when a later call fails.
+type LastKnownGood[T any] struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	clock   clock.Clock
+	entries map[string]lastKnownGoodEntry[T]
+	pruneAt int // number of entries at which expired ones are next removed
+}
+
+// NewLastKnownGood creates a LastKnownGood cache keeping values for ttl.
+// If clk is nil, the real clock is used.
+func NewLastKnownGood[T any](ttl time.Duration, clk Clock) *LastKnownGood[T] {
+	return &LastKnownGood[T]{
+		ttl:     ttl,
+		clock:   clock.OrReal(clk),
+		entries: make(map[string]lastKnownGoodEntry[T]),
+		pruneAt: 64,
+	}
+}
+
+// Store caches value as the last known good result for key.
+func (c *LastKnownGood[T]) Store(key string, value T) {
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = lastKnownGoodEntry[T]{value: value, expires: now.Add(c.ttl)}
+
+	// Expired entries are otherwise only removed when loaded, so remove them
+	// whenever the cache doubled in size since the last time
+	if len(c.entries) >= c.pruneAt {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.pruneAt = max(2*len(c.entries), 64)
+	}
+}
+
+// Load returns the last known good result for key, if it has not expired.
+func (c *LastKnownGood[T]) Load(key string) (T, bool) {
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	if !now.Before(e.expires) {
+		delete(c.entries, key)
+		var zero T
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Fallback returns a strategy falling back to the last known good result for
+// the request's key, named "last-known-good".
+func (c *LastKnownGood[T]) Fallback(when FallbackCondition) FallbackStrategy[T] {
+	return FallbackStrategy[T]{
+		Name: "last-known-good",
+		When: when,
+		Fallback: func(ctx context.Context, key string, err error) (T, error) {
+			if value, ok := c.Load(key); ok {
+				return value, nil
+			}
+			var zero T
+			return zero, errNoCachedValue
+		},
+	}
+}
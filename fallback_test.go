@@ -0,0 +1,240 @@
+package gomian
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nutcase/gomian/clocktest"
+)
+
+// failingStrategy returns a strategy that records being tried and fails.
+func failingStrategy(name string, when FallbackCondition, tried *[]string) FallbackStrategy[string] {
+	return FallbackStrategy[string]{
+		Name: name,
+		When: when,
+		Fallback: func(ctx context.Context, key string, err error) (string, error) {
+			*tried = append(*tried, name)
+			return "", errors.New(name + " unavailable")
+		},
+	}
+}
+
+func TestFallbackChain(t *testing.T) {
+	var tried []string
+	chain := NewFallbackChain(FallbackChainSettings[string]{
+		Name: "TestChain",
+		Strategies: []FallbackStrategy[string]{
+			failingStrategy("secondary", FallbackOnAny, &tried),
+			failingStrategy("rejections-only", FallbackOnRejection, &tried),
+			StaticFallback("default", FallbackOnAny, "static"),
+		},
+	})
+
+	var recovered []string
+	chain.OnFallback(func(name, strategy string, err error) {
+		recovered = append(recovered, strategy)
+	})
+
+	testErr := errors.New("test error")
+	v, err := chain.Execute(context.Background(), "key", func(ctx context.Context) (string, error) {
+		return "", testErr
+	})
+	if err != nil || v != "static" {
+		t.Fatalf("Execute should fall back to the static value, got %q, %v", v, err)
+	}
+	if len(tried) != 1 || tried[0] != "secondary" {
+		t.Errorf("Only strategies applying to failures should be tried, got %v", tried)
+	}
+
+	// Rejections go through every strategy applying to them
+	tried = nil
+	v, err = chain.Execute(context.Background(), "key", func(ctx context.Context) (string, error) {
+		return "", &CircuitError{Name: "TestBreaker", Err: ErrCircuitOpen}
+	})
+	if err != nil || v != "static" {
+		t.Fatalf("Execute should fall back to the static value, got %q, %v", v, err)
+	}
+	if len(tried) != 2 || tried[1] != "rejections-only" {
+		t.Errorf("Strategies should be tried in order, got %v", tried)
+	}
+
+	metrics := chain.GetMetrics()
+	if metrics.Calls != 2 || metrics.Failures != 1 || metrics.Rejections != 1 || metrics.Recovered["default"] != 2 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+	if len(recovered) != 2 {
+		t.Errorf("Fallback callback should be called twice, got %v", recovered)
+	}
+}
+
+func TestFallbackChainUnrecovered(t *testing.T) {
+	var tried []string
+	notFound := errors.New("not found")
+	chain := NewFallbackChain(FallbackChainSettings[string]{
+		Strategies: []FallbackStrategy[string]{
+			failingStrategy("secondary", FallbackOnFailure, &tried),
+		},
+		IsFailure: func(err error) bool {
+			return err != notFound
+		},
+	})
+
+	// Errors that are not failures are returned without falling back
+	_, err := chain.Execute(context.Background(), "key", func(ctx context.Context) (string, error) {
+		return "", notFound
+	})
+	if err != notFound || len(tried) != 0 {
+		t.Errorf("Non-failure should be returned as is, got %v after trying %v", err, tried)
+	}
+
+	// Without a strategy succeeding, the original error is returned
+	testErr := errors.New("test error")
+	_, err = chain.Execute(context.Background(), "key", func(ctx context.Context) (string, error) {
+		return "", testErr
+	})
+	if err != testErr || len(tried) != 1 {
+		t.Errorf("Original error should be returned, got %v after trying %v", err, tried)
+	}
+	if metrics := chain.GetMetrics(); metrics.Unrecovered != 1 || metrics.Failures != 1 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+}
+
+func TestFallbackChainBreakerClassification(t *testing.T) {
+	notFound := errors.New("not found")
+	cb, err := New("TestBreaker", WithConsecutiveFailures(5), WithIgnoredErrors(notFound))
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	chain := NewFallbackChain(FallbackChainSettings[string]{
+		Breaker:    cb,
+		Strategies: []FallbackStrategy[string]{StaticFallback("default", FallbackOnAny, "static")},
+	})
+
+	// Errors the breaker ignores are returned without falling back
+	v, err := chain.Execute(context.Background(), "key", func(ctx context.Context) (string, error) {
+		return "", notFound
+	})
+	if err != notFound || v != "" {
+		t.Errorf("Ignored error should be returned as is, got %q, %v", v, err)
+	}
+
+	// Failures go through the breaker and fall back
+	v, err = chain.Execute(context.Background(), "key", func(ctx context.Context) (string, error) {
+		return "", errors.New("test error")
+	})
+	if err != nil || v != "static" {
+		t.Errorf("Failure should fall back to the static value, got %q, %v", v, err)
+	}
+	if m := cb.GetMetrics(); m.TotalFailures != 1 || m.IgnoredResults != 1 {
+		t.Errorf("Calls should be recorded by the breaker, got %+v", m)
+	}
+
+	v, err = chain.Execute(context.Background(), "key", func(ctx context.Context) (string, error) {
+		return "value", nil
+	})
+	if err != nil || v != "value" {
+		t.Errorf("Execute should return the result, got %q, %v", v, err)
+	}
+	if metrics := chain.GetMetrics(); metrics.Failures != 1 || metrics.Recovered["default"] != 1 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+}
+
+func TestFallbackChainCallerCanceled(t *testing.T) {
+	chain := NewFallbackChain(FallbackChainSettings[string]{
+		Strategies: []FallbackStrategy[string]{StaticFallback("default", FallbackOnAny, "static")},
+	})
+
+	// A caller giving up is not recovered from
+	ctx, cancel := context.WithCancel(context.Background())
+	v, err := chain.Execute(ctx, "key", func(ctx context.Context) (string, error) {
+		cancel()
+		return "", ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) || v != "" {
+		t.Errorf("Canceled call should not fall back, got %q, %v", v, err)
+	}
+	if metrics := chain.GetMetrics(); len(metrics.Recovered) != 0 || metrics.Failures != 0 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+}
+
+func TestLastKnownGood(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	cache := NewLastKnownGood[int](time.Minute, clk)
+	chain := NewFallbackChain(FallbackChainSettings[int]{
+		Cache:      cache,
+		Strategies: []FallbackStrategy[int]{cache.Fallback(FallbackOnAny)},
+	})
+
+	ok := func(v int) func(context.Context) (int, error) {
+		return func(ctx context.Context) (int, error) { return v, nil }
+	}
+	testErr := errors.New("test error")
+	fail := func(ctx context.Context) (int, error) { return 0, testErr }
+
+	if _, err := chain.Execute(context.Background(), "a", ok(1)); err != nil {
+		t.Fatalf("Execute should succeed, got %v", err)
+	}
+	chain.Execute(context.Background(), "b", ok(2))
+
+	// Failed calls get the last result for their key
+	if v, err := chain.Execute(context.Background(), "a", fail); err != nil || v != 1 {
+		t.Errorf("Execute should return the last known good value 1, got %v, %v", v, err)
+	}
+	if _, err := chain.Execute(context.Background(), "c", fail); err != testErr {
+		t.Errorf("Execute should fail for a key without a cached value, got %v", err)
+	}
+
+	// Values expire after the TTL
+	clk.Advance(30 * time.Second)
+	chain.Execute(context.Background(), "b", ok(3))
+	clk.Advance(30 * time.Second)
+	if _, err := chain.Execute(context.Background(), "a", fail); err != testErr {
+		t.Errorf("Expired value should not be served, got %v", err)
+	}
+	if v, err := chain.Execute(context.Background(), "b", fail); err != nil || v != 3 {
+		t.Errorf("Execute should return the refreshed value 3, got %v, %v", v, err)
+	}
+	if metrics := chain.GetMetrics(); metrics.Recovered["last-known-good"] != 2 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+}
+
+func TestLastKnownGoodPrune(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	cache := NewLastKnownGood[int](time.Second, clk)
+
+	for i := range 63 {
+		cache.Store(strconv.Itoa(i), i)
+	}
+	clk.Advance(time.Second)
+	cache.Store("fresh", 1)
+
+	if n := len(cache.entries); n != 1 {
+		t.Errorf("Expired entries should be removed once the cache grew, got %d entries", n)
+	}
+	if v, ok := cache.Load("fresh"); !ok || v != 1 {
+		t.Errorf("Fresh entry should be kept, got %v, %v", v, ok)
+	}
+}
+
+func TestFallbackConditionString(t *testing.T) {
+	tests := map[FallbackCondition]string{
+		FallbackOnAny:         "Any",
+		FallbackOnRejection:   "Rejection",
+		FallbackOnFailure:     "Failure",
+		FallbackCondition(42): "Unknown FallbackCondition(42)",
+	}
+	for c, want := range tests {
+		if got := c.String(); got != want {
+			t.Errorf("FallbackCondition(%d).String() = %q, want %q", int(c), got, want)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package gomian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nutcase/gomian/internal/clock"
+	"github.com/nutcase/gomian/internal/counter"
+)
+
+// HedgeSettings defines the configuration for a Hedger.
+type HedgeSettings struct {
+	// Name is a unique identifier for this hedger.
+	Name string
+
+	// Delay is how long a call waits for a response before a backup call is
+	// made to the next target.
+	Delay time.Duration
+
+	// Percentile, if positive, makes the delay the given percentile of the
+	// latencies of successful calls within LatencyWindow, e.g. 95. Delay is
+	// used until MinSamples latencies have been observed.
+	Percentile    float64
+	MinSamples    uint64
+	LatencyWindow time.Duration
+
+	// MaxHedges is the maximum number of backup calls per call.
+	MaxHedges int
+
+	// Clock is the source of time for the delays and the latency window.
+	// If nil, the real clock is used.
+	Clock Clock
+}
+
+// DefaultHedgeSettings returns a HedgeSettings struct with sensible default values.
+func DefaultHedgeSettings() HedgeSettings {
+	return HedgeSettings{
+		Name:          "default",
+		Delay:         100 * time.Millisecond,
+		MinSamples:    20,
+		LatencyWindow: time.Minute,
+		MaxHedges:     1,
+	}
+}
+
+// Validate checks the settings for inconsistent or out-of-range values.
+// All problems are reported together, each wrapping ErrInvalidSettings.
+func (s HedgeSettings) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrInvalidSettings, fmt.Sprintf(format, args...)))
+	}
+
+	if s.Name == "" {
+		invalid("name must not be empty")
+	}
+	if s.Delay <= 0 {
+		invalid("hedge delay must be positive, got %v", s.Delay)
+	}
+	if s.Percentile < 0 || s.Percentile > 100 {
+		invalid("hedge percentile must be between 0 and 100, got %v", s.Percentile)
+	}
+	if s.Percentile > 0 && s.LatencyWindow <= 0 {
+		invalid("latency window must be positive, got %v", s.LatencyWindow)
+	}
+	if s.MaxHedges < 1 {
+		invalid("max hedges must be at least 1, got %d", s.MaxHedges)
+	}
+
+	return errors.Join(errs...)
+}
+
+// HedgeCallback is a function that is called when a Hedger makes a backup
+// call to the target with the given index.
+type HedgeCallback func(name string, target int)
+
+// HedgeMetrics represents the current metrics of a Hedger.
+type HedgeMetrics struct {
+	Name     string
+	Calls    uint64
+	Failures uint64
+
+	// Hedges is the number of backup calls made, and HedgeWins the number of
+	// calls won by a backup call.
+	Hedges    uint64
+	HedgeWins uint64
+
+	// SkippedTargets is the number of times a target was skipped because its circuit was Open.
+	SkippedTargets uint64
+
+	// HedgeDelay is the delay after which the next call makes a backup call.
+	HedgeDelay time.Duration
+}
+
+// Hedger makes hedged calls to replicated targets, each guarded by its own
+// circuit breaker. A call goes to the first target whose circuit is not Open.
+// If it has not succeeded after the hedge delay, or has failed, a backup call
+// is made to the next such target, and the first success is returned. The
+// calls still running are then canceled.
+//
+// Each target's breaker only counts the real outcome of the calls it made: a
+// call canceled because another one won has its caller's context canceled,
+// so it is ignored unless the breaker's CallerContextErrors say otherwise.
+type Hedger struct {
+	name      string
+	settings  HedgeSettings
+	clock     clock.Clock
+	targets   []*CircuitBreaker
+	latency   *counter.LatencyHistogram
+	calls     atomic.Uint64
+	failures  atomic.Uint64
+	hedges    atomic.Uint64
+	hedgeWins atomic.Uint64
+	skipped   atomic.Uint64
+	onHedge   []HedgeCallback
+}
+
+// NewHedger creates a new Hedger calling the given targets, in order of
+// preference, each identified by its index in the operation.
+func NewHedger(settings HedgeSettings, targets ...*CircuitBreaker) *Hedger {
+	defaults := DefaultHedgeSettings()
+	if settings.Name == "" {
+		settings.Name = defaults.Name
+	}
+	if settings.Delay <= 0 {
+		settings.Delay = defaults.Delay
+	}
+	if settings.MinSamples == 0 {
+		settings.MinSamples = defaults.MinSamples
+	}
+	if settings.LatencyWindow <= 0 {
+		settings.LatencyWindow = defaults.LatencyWindow
+	}
+	if settings.MaxHedges <= 0 {
+		settings.MaxHedges = defaults.MaxHedges
+	}
+
+	h := &Hedger{
+		name:     settings.Name,
+		settings: settings,
+		clock:    clock.OrReal(settings.Clock),
+		targets:  targets,
+	}
+	h.latency = counter.NewLatencyHistogram(settings.LatencyWindow, 0, h.clock)
+
+	return h
+}
+
+// ExecuteContext executes the given function with context against one or
+// more targets, passing it the index of the target to call, until a call
+// succeeds. It returns the error of the last failed call if none did.
+func (h *Hedger) ExecuteContext(ctx context.Context, op func(ctx context.Context, target int) error) error {
+	_, err := Hedge(ctx, h, func(ctx context.Context, target int) (struct{}, error) {
+		return struct{}{}, op(ctx, target)
+	})
+	return err
+}
+
+// hedgeResult is the result of a call made by a Hedger.
+type hedgeResult[T any] struct {
+	target  int
+	value   T
+	err     error
+	elapsed time.Duration
+}
+
+// Hedge executes op with context through the hedger and returns the result
+// of the first call that succeeded.
+func Hedge[T any](ctx context.Context, h *Hedger, op func(ctx context.Context, target int) (T, error)) (T, error) {
+	var zero T
+	if ctx.Err() != nil {
+		return zero, ctx.Err()
+	}
+	if len(h.targets) == 0 {
+		return zero, fmt.Errorf("hedger '%s' has no targets", h.name)
+	}
+	h.calls.Add(1)
+
+	// Calls still running when Hedge returns are canceled, and their results
+	// dropped into the buffered channel
+	callCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan hedgeResult[T], len(h.targets))
+
+	running := 0
+	start := func(target int) {
+		running++
+		go func() {
+			// An abandoned call may still set its value after its breaker returned
+			var mu sync.Mutex
+			var value T
+			began := h.clock.Now()
+			err := h.targets[target].ExecuteContext(callCtx, func(ctx context.Context) error {
+				v, err := op(ctx, target)
+				mu.Lock()
+				value = v
+				mu.Unlock()
+				return err
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			results <- hedgeResult[T]{target, value, err, h.clock.Since(began)}
+		}()
+	}
+
+	next, hedges := 0, 0
+	launch := func() bool {
+		for next < len(h.targets) {
+			target := next
+			next++
+			if h.targets[target].State() == Open {
+				h.skipped.Add(1)
+				continue
+			}
+			start(target)
+			return true
+		}
+		return false
+	}
+
+	// If every circuit is Open, let the first breaker reject the call
+	primary := 0
+	if launch() {
+		primary = next - 1
+	} else {
+		start(primary)
+	}
+
+	hedge := func() {
+		if hedges < h.settings.MaxHedges && launch() {
+			hedges++
+			h.hedges.Add(1)
+			for _, callback := range h.onHedge {
+				callback(h.name, next-1)
+			}
+		}
+	}
+
+	var lastErr error
+	for running > 0 {
+		var timer clock.Timer
+		fire := make(chan struct{})
+		if hedges < h.settings.MaxHedges && next < len(h.targets) {
+			timer = h.clock.AfterFunc(h.hedgeDelay(), func() {
+				close(fire)
+			})
+		}
+
+		select {
+		case r := <-results:
+			running--
+			if r.err == nil {
+				stopTimer(timer)
+				h.latency.Record(r.elapsed)
+				if r.target != primary {
+					h.hedgeWins.Add(1)
+				}
+				return r.value, nil
+			}
+			lastErr = r.err
+			// Fail over to the next target right away
+			hedge()
+		case <-fire:
+			hedge()
+		case <-ctx.Done():
+			stopTimer(timer)
+			h.failures.Add(1)
+			return zero, ctx.Err()
+		}
+		stopTimer(timer)
+	}
+
+	h.failures.Add(1)
+	return zero, lastErr
+}
+
+// stopTimer stops timer if it is set.
+func stopTimer(timer clock.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// hedgeDelay returns how long a call waits before making a backup call.
+func (h *Hedger) hedgeDelay() time.Duration {
+	if h.settings.Percentile > 0 {
+		if d, samples := h.latency.Percentile(h.settings.Percentile); samples >= h.settings.MinSamples && d > 0 {
+			return d
+		}
+	}
+	return h.settings.Delay
+}
+
+// OnHedge registers a callback for backup calls.
+func (h *Hedger) OnHedge(callback HedgeCallback) {
+	h.onHedge = append(h.onHedge, callback)
+}
+
+// Name returns the name of the hedger.
+func (h *Hedger) Name() string {
+	return h.name
+}
+
+// GetMetrics returns the current metrics of the hedger.
+func (h *Hedger) GetMetrics() HedgeMetrics {
+	return HedgeMetrics{
+		Name:           h.name,
+		Calls:          h.calls.Load(),
+		Failures:       h.failures.Load(),
+		Hedges:         h.hedges.Load(),
+		HedgeWins:      h.hedgeWins.Load(),
+		SkippedTargets: h.skipped.Load(),
+		HedgeDelay:     h.hedgeDelay(),
+	}
+}
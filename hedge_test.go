@@ -0,0 +1,194 @@
+package gomian
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nutcase/gomian/clocktest"
+)
+
+// newHedgeTargets creates n circuit breakers tripping on a single failure.
+func newHedgeTargets(t *testing.T, n int, clk Clock) []*CircuitBreaker {
+	t.Helper()
+
+	targets := make([]*CircuitBreaker, n)
+	for i := range targets {
+		cb, err := New("target-"+string(rune('a'+i)),
+			WithConsecutiveFailures(1),
+			WithTimeout(time.Hour),
+			WithClock(clk),
+		)
+		if err != nil {
+			t.Fatalf("New should succeed, got %v", err)
+		}
+		t.Cleanup(cb.Close)
+		targets[i] = cb
+	}
+	return targets
+}
+
+func TestHedgerBackupWins(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	targets := newHedgeTargets(t, 2, clk)
+	h := NewHedger(HedgeSettings{Name: "TestHedger", Delay: 100 * time.Millisecond, Clock: clk}, targets...)
+
+	var hedged []int
+	h.OnHedge(func(name string, target int) {
+		hedged = append(hedged, target)
+	})
+
+	type result struct {
+		value string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := Hedge(context.Background(), h, func(ctx context.Context, target int) (string, error) {
+			if target == 0 {
+				<-ctx.Done()
+				return "", ctx.Err()
+			}
+			return "backup", nil
+		})
+		done <- result{v, err}
+	}()
+
+	// The primary call does not respond, so a backup call is made after the delay
+	waitFor(t, func() bool { return clk.PendingTimers() == 1 })
+	clk.Advance(100 * time.Millisecond)
+
+	r := <-done
+	if r.err != nil || r.value != "backup" {
+		t.Fatalf("Hedge should return the backup's result, got %q, %v", r.value, r.err)
+	}
+	if len(hedged) != 1 || hedged[0] != 1 {
+		t.Errorf("Hedge callback should be called for target 1, got %v", hedged)
+	}
+
+	metrics := h.GetMetrics()
+	if metrics.Calls != 1 || metrics.Hedges != 1 || metrics.HedgeWins != 1 || metrics.Failures != 0 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+
+	// The canceled loser is not counted against its breaker
+	waitFor(t, func() bool { return targets[0].GetMetrics().IgnoredResults == 1 })
+	if m := targets[0].GetMetrics(); m.TotalFailures != 0 || m.State != Closed {
+		t.Errorf("Canceled call should not count as a failure, got %+v", m)
+	}
+	if m := targets[1].GetMetrics(); m.TotalRequests != 1 || m.TotalFailures != 0 {
+		t.Errorf("Winner should count as a success, got %+v", m)
+	}
+}
+
+func TestHedgerPrimaryWins(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	targets := newHedgeTargets(t, 2, clk)
+	h := NewHedger(HedgeSettings{Delay: 100 * time.Millisecond, Clock: clk}, targets...)
+
+	err := h.ExecuteContext(context.Background(), func(ctx context.Context, target int) error {
+		if target != 0 {
+			t.Errorf("Only the primary target should be called, got %d", target)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("ExecuteContext should succeed, got %v", err)
+	}
+	if metrics := h.GetMetrics(); metrics.Hedges != 0 || metrics.HedgeWins != 0 {
+		t.Errorf("No backup call should be made, got %+v", metrics)
+	}
+	if clk.PendingTimers() != 0 {
+		t.Errorf("Hedge timer should be stopped, got %d pending", clk.PendingTimers())
+	}
+}
+
+func TestHedgerFailover(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	targets := newHedgeTargets(t, 3, clk)
+	h := NewHedger(HedgeSettings{Delay: time.Hour, MaxHedges: 2, Clock: clk}, targets...)
+
+	// A failed call fails over to the next target without waiting for the delay
+	testErr := errors.New("test error")
+	var called []int
+	err := h.ExecuteContext(context.Background(), func(ctx context.Context, target int) error {
+		called = append(called, target)
+		if target < 2 {
+			return testErr
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExecuteContext should succeed on the last target, got %v", err)
+	}
+	if len(called) != 3 {
+		t.Errorf("Every target should be called in turn, got %v", called)
+	}
+	if targets[0].State() != Open || targets[1].State() != Open {
+		t.Errorf("Failed calls should count against their breakers")
+	}
+
+	// Open targets are skipped
+	called = nil
+	err = h.ExecuteContext(context.Background(), func(ctx context.Context, target int) error {
+		called = append(called, target)
+		return nil
+	})
+	if err != nil || len(called) != 1 || called[0] != 2 {
+		t.Errorf("Only the closed target should be called, got %v, %v", called, err)
+	}
+	if metrics := h.GetMetrics(); metrics.SkippedTargets != 2 || metrics.HedgeWins != 1 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+
+	// Once every circuit is Open, the call is rejected
+	targets[2].ForceOpen()
+	err = h.ExecuteContext(context.Background(), func(ctx context.Context, target int) error {
+		t.Error("No target should be called when every circuit is open")
+		return nil
+	})
+	var circuitErr *CircuitError
+	if !errors.As(err, &circuitErr) || circuitErr.Name != "target-a" {
+		t.Errorf("ExecuteContext should return the first target's rejection, got %v", err)
+	}
+}
+
+func TestHedgerPercentileDelay(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	targets := newHedgeTargets(t, 2, clk)
+	h := NewHedger(HedgeSettings{
+		Delay:      time.Hour,
+		Percentile: 95,
+		MinSamples: 5,
+		Clock:      clk,
+	}, targets...)
+
+	for range 5 {
+		if h.GetMetrics().HedgeDelay != time.Hour {
+			t.Fatalf("Delay should be used until MinSamples latencies are observed")
+		}
+		h.ExecuteContext(context.Background(), func(ctx context.Context, target int) error {
+			clk.Advance(50 * time.Millisecond)
+			return nil
+		})
+	}
+
+	if d := h.GetMetrics().HedgeDelay; d < 45*time.Millisecond || d > 55*time.Millisecond {
+		t.Errorf("Delay should be the observed p95 of about 50ms, got %v", d)
+	}
+}
+
+func TestHedgeSettingsValidate(t *testing.T) {
+	if err := DefaultHedgeSettings().Validate(); err != nil {
+		t.Errorf("Default settings should be valid, got %v", err)
+	}
+
+	err := HedgeSettings{Name: "TestHedger", Percentile: 101, MaxHedges: 0}.Validate()
+	if !errors.Is(err, ErrInvalidSettings) {
+		t.Fatalf("Validate should return ErrInvalidSettings, got %v", err)
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 4 {
+		t.Errorf("Validate should report 4 problems, got %d: %v", n, err)
+	}
+}
@@ -0,0 +1,93 @@
+package clock
+
+import (
+	"context"
+	"time"
+)
+
+// Clock abstracts the passage of time so that it can be controlled in tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Since returns the time elapsed since t.
+	Since(t time.Time) time.Duration
+	// AfterFunc waits for the duration to elapse and then calls f in its own goroutine.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a handle to a function scheduled with Clock.AfterFunc.
+type Timer interface {
+	// Stop prevents the timer from firing. It returns false if the timer
+	// has already fired or been stopped.
+	Stop() bool
+}
+
+// realClock is a Clock backed by the time package.
+type realClock struct{}
+
+// Real returns a Clock backed by the time package.
+func Real() Clock {
+	return realClock{}
+}
+
+// Now returns time.Now().
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Since returns time.Since(t).
+func (realClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+// AfterFunc wraps time.AfterFunc.
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// OrReal returns c, or the real clock if c is nil.
+func OrReal(c Clock) Clock {
+	if c == nil {
+		return Real()
+	}
+	return c
+}
+
+// WithTimeoutCause returns a copy of parent that is canceled with cause once d
+// has elapsed on c. With the real clock this is context.WithTimeoutCause, so
+// the returned context carries a deadline; other clocks cancel it from a
+// timer, so that the timeout follows their notion of time.
+func WithTimeoutCause(parent context.Context, c Clock, d time.Duration, cause error) (context.Context, context.CancelFunc) {
+	if _, ok := OrReal(c).(realClock); ok {
+		return context.WithTimeoutCause(parent, d, cause)
+	}
+
+	ctx, cancel := context.WithCancelCause(parent)
+	timer := c.AfterFunc(d, func() {
+		cancel(cause)
+	})
+	return ctx, func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// Sleep pauses for d on c. It returns ctx's error if ctx is done first.
+func Sleep(ctx context.Context, c Clock, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	done := make(chan struct{})
+	timer := OrReal(c).AfterFunc(d, func() {
+		close(done)
+	})
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,148 @@
+package clock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRealClock(t *testing.T) {
+	c := Real()
+
+	before := time.Now()
+	now := c.Now()
+	if now.Before(before) {
+		t.Errorf("Now should not be before time.Now(), got %v < %v", now, before)
+	}
+
+	if c.Since(before) < 0 {
+		t.Error("Since should not be negative")
+	}
+
+	fired := make(chan struct{})
+	c.AfterFunc(time.Millisecond, func() {
+		close(fired)
+	})
+
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Error("AfterFunc should fire")
+	}
+}
+
+func TestRealClockStop(t *testing.T) {
+	timer := Real().AfterFunc(time.Hour, func() {
+		t.Error("Stopped timer should not fire")
+	})
+
+	if !timer.Stop() {
+		t.Error("Stop should return true for a pending timer")
+	}
+	if timer.Stop() {
+		t.Error("Stop should return false for an already stopped timer")
+	}
+}
+
+func TestOrReal(t *testing.T) {
+	if OrReal(nil) == nil {
+		t.Error("OrReal(nil) should return the real clock")
+	}
+
+	c := Real()
+	if OrReal(c) != c {
+		t.Error("OrReal should return the provided clock")
+	}
+}
+
+// manualClock is a Clock whose timers only fire when fire is called.
+type manualClock struct {
+	realClock
+	mu      sync.Mutex
+	pending []func()
+}
+
+func (c *manualClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, f)
+	return time.NewTimer(time.Hour)
+}
+
+func (c *manualClock) pendingFuncs() []func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pending
+}
+
+func (c *manualClock) fire() {
+	for _, f := range c.pendingFuncs() {
+		f()
+	}
+}
+
+func TestWithTimeoutCause(t *testing.T) {
+	cause := errors.New("timed out")
+
+	// The real clock gives the context a deadline
+	ctx, cancel := WithTimeoutCause(context.Background(), Real(), time.Millisecond, cause)
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("Context should have a deadline with the real clock")
+	}
+	<-ctx.Done()
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) || context.Cause(ctx) != cause {
+		t.Errorf("Context should expire with the cause, got %v and %v", ctx.Err(), context.Cause(ctx))
+	}
+
+	// Other clocks cancel the context from their timers
+	c := &manualClock{}
+	ctx, cancel = WithTimeoutCause(context.Background(), c, time.Second, cause)
+	defer cancel()
+	if ctx.Err() != nil {
+		t.Fatal("Context should not be done before the timer fires")
+	}
+	c.fire()
+	if ctx.Err() == nil || context.Cause(ctx) != cause {
+		t.Errorf("Context should be canceled with the cause, got %v", context.Cause(ctx))
+	}
+
+	// Canceling first does not report the cause
+	ctx, cancel = WithTimeoutCause(context.Background(), &manualClock{}, time.Second, cause)
+	cancel()
+	if context.Cause(ctx) != context.Canceled {
+		t.Errorf("Canceled context should not report the timeout cause, got %v", context.Cause(ctx))
+	}
+}
+
+func TestSleep(t *testing.T) {
+	if err := Sleep(context.Background(), Real(), time.Millisecond); err != nil {
+		t.Errorf("Sleep should return nil once the duration elapsed, got %v", err)
+	}
+
+	// Timers of other clocks end the sleep when they fire
+	c := &manualClock{}
+	done := make(chan error)
+	go func() {
+		done <- Sleep(context.Background(), c, time.Second)
+	}()
+	for {
+		time.Sleep(time.Millisecond)
+		if len(c.pendingFuncs()) == 1 {
+			break
+		}
+	}
+	c.fire()
+	if err := <-done; err != nil {
+		t.Errorf("Sleep should return nil once the timer fired, got %v", err)
+	}
+
+	// A done context ends the sleep early
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Sleep(ctx, &manualClock{}, time.Second); !errors.Is(err, context.Canceled) {
+		t.Errorf("Sleep should return the context error, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package counter
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Outcomes stored in a CountWindow slot. A failure is stored as slotFailure
+// plus its weight in weightScale units beyond the first, so any value of
+// slotFailure or more is a failure.
+const (
+	slotEmpty uint32 = iota
+	slotSuccess
+	slotFailure
+)
+
+// slotWeight returns the failure weight units stored in a slot, or zero if it holds no failure.
+func slotWeight(outcome uint32) int64 {
+	if outcome < slotFailure {
+		return 0
+	}
+	return int64(outcome-slotFailure) + 1
+}
+
+// CountWindow is a sliding window over the last N requests, regardless of how
+// long they took. It is a ring buffer of outcomes; recording an outcome
+// overwrites the oldest one once the buffer is full.
+//
+// Recording is lock-free: the next slot is claimed with an atomic increment,
+// the outcome is swapped in, and the running totals are adjusted for whatever
+// outcome it replaced.
+type CountWindow struct {
+	slots    []atomic.Uint32
+	next     atomic.Uint64
+	requests atomic.Int64
+	failures atomic.Int64
+	weight   atomic.Int64 // sum of failure weights in weightScale units
+}
+
+// NewCountWindow creates a new CountWindow holding the last size outcomes.
+func NewCountWindow(size int) *CountWindow {
+	if size <= 0 {
+		size = 100 // Default to the last 100 requests
+	}
+
+	return &CountWindow{
+		slots: make([]atomic.Uint32, size),
+	}
+}
+
+// record stores an outcome in the next slot of the ring.
+func (cw *CountWindow) record(outcome uint32) {
+	i := (cw.next.Add(1) - 1) % uint64(len(cw.slots))
+	cw.replace(&cw.slots[i], outcome)
+}
+
+// replace swaps outcome into slot and updates the totals accordingly.
+func (cw *CountWindow) replace(slot *atomic.Uint32, outcome uint32) {
+	old := slot.Swap(outcome)
+
+	switch {
+	case old == slotEmpty && outcome != slotEmpty:
+		cw.requests.Add(1)
+	case old != slotEmpty && outcome == slotEmpty:
+		cw.requests.Add(-1)
+	}
+
+	if old >= slotFailure {
+		cw.failures.Add(-1)
+		cw.weight.Add(-slotWeight(old))
+	}
+	if outcome >= slotFailure {
+		cw.failures.Add(1)
+		cw.weight.Add(slotWeight(outcome))
+	}
+}
+
+// IncrementSuccess records a successful request.
+func (cw *CountWindow) IncrementSuccess() {
+	cw.record(slotSuccess)
+}
+
+// IncrementFailure records a failed request.
+func (cw *CountWindow) IncrementFailure() {
+	cw.AddFailure(1)
+}
+
+// AddFailure records a failed request weighing weight.
+func (cw *CountWindow) AddFailure(weight float64) {
+	// The largest weight a slot can hold is slightly below that of toWeightUnits
+	units := min(toWeightUnits(weight), math.MaxUint32-uint64(slotFailure)+1)
+	cw.record(slotFailure + uint32(units-1))
+}
+
+// Counts returns the number of requests and failures among the last size requests.
+func (cw *CountWindow) Counts() (requests, failures uint64) {
+	// The totals can be momentarily negative while a Reset races with a
+	// recording, since the two adjust them in separate steps.
+	return clampUint64(cw.requests.Load()), clampUint64(cw.failures.Load())
+}
+
+// WeightedFailures returns the sum of the weights of the failures among the last size requests.
+func (cw *CountWindow) WeightedFailures() float64 {
+	return fromWeightUnits(clampUint64(cw.weight.Load()))
+}
+
+// Reset clears all recorded outcomes.
+func (cw *CountWindow) Reset() {
+	for i := range cw.slots {
+		cw.replace(&cw.slots[i], slotEmpty)
+	}
+}
+
+// clampUint64 converts v to a uint64, treating negative values as zero.
+func clampUint64(v int64) uint64 {
+	if v < 0 {
+		return 0
+	}
+	return uint64(v)
+}
@@ -0,0 +1,147 @@
+package counter
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestCountWindow(t *testing.T) {
+	cw := NewCountWindow(4)
+
+	// Test initial state
+	requests, failures := cw.Counts()
+	if requests != 0 || failures != 0 {
+		t.Errorf("Initial state should be 0 for both counters, got %d requests and %d failures",
+			requests, failures)
+	}
+
+	cw.IncrementFailure()
+	cw.IncrementFailure()
+	cw.IncrementSuccess()
+	requests, failures = cw.Counts()
+	if requests != 3 || failures != 2 {
+		t.Errorf("Should have 3 requests and 2 failures, got %d requests and %d failures",
+			requests, failures)
+	}
+
+	// Fill the window and start overwriting the oldest outcomes
+	cw.IncrementSuccess()
+	cw.IncrementSuccess()
+	requests, failures = cw.Counts()
+	if requests != 4 || failures != 1 {
+		t.Errorf("After overwriting one failure, should have 4 requests and 1 failure, got %d requests and %d failures",
+			requests, failures)
+	}
+
+	cw.IncrementSuccess()
+	requests, failures = cw.Counts()
+	if requests != 4 || failures != 0 {
+		t.Errorf("After overwriting both failures, should have 4 requests and 0 failures, got %d requests and %d failures",
+			requests, failures)
+	}
+
+	// Test reset
+	cw.Reset()
+	requests, failures = cw.Counts()
+	if requests != 0 || failures != 0 {
+		t.Errorf("After reset, should have 0 requests and 0 failures, got %d requests and %d failures",
+			requests, failures)
+	}
+}
+
+func TestCountWindowMatchesLastN(t *testing.T) {
+	const size = 20
+	cw := NewCountWindow(size)
+
+	var outcomes []bool
+	for i := 0; i < 137; i++ {
+		failed := i%3 == 0 || i%7 == 0
+		outcomes = append(outcomes, failed)
+		if failed {
+			cw.IncrementFailure()
+		} else {
+			cw.IncrementSuccess()
+		}
+
+		// Compare with the last size outcomes
+		start := len(outcomes) - size
+		if start < 0 {
+			start = 0
+		}
+		var wantFailures uint64
+		for _, f := range outcomes[start:] {
+			if f {
+				wantFailures++
+			}
+		}
+
+		requests, failures := cw.Counts()
+		if requests != uint64(len(outcomes[start:])) || failures != wantFailures {
+			t.Fatalf("After %d outcomes, should have %d requests and %d failures, got %d requests and %d failures",
+				i+1, len(outcomes[start:]), wantFailures, requests, failures)
+		}
+	}
+}
+
+func TestCountWindowConcurrent(t *testing.T) {
+	cw := NewCountWindow(50)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if i%2 == 0 {
+					cw.IncrementFailure()
+				} else {
+					cw.IncrementSuccess()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	requests, failures := cw.Counts()
+	if requests != 50 {
+		t.Errorf("A full window should hold 50 requests, got %d", requests)
+	}
+	if failures > requests {
+		t.Errorf("Failures should not exceed requests, got %d failures and %d requests", failures, requests)
+	}
+}
+
+func BenchmarkCountWindowIncrement(b *testing.B) {
+	cw := NewCountWindow(100)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cw.IncrementSuccess()
+		}
+	})
+}
+
+func TestCountWindowWeightedFailures(t *testing.T) {
+	cw := NewCountWindow(3)
+
+	cw.AddFailure(2.5)
+	cw.IncrementFailure()
+	cw.IncrementSuccess()
+	if w := cw.WeightedFailures(); w != 3.5 {
+		t.Errorf("Weighted failures should be 3.5, got %v", w)
+	}
+	if requests, failures := cw.Counts(); requests != 3 || failures != 2 {
+		t.Errorf("Should have 3 requests and 2 failures, got %d requests and %d failures", requests, failures)
+	}
+
+	// Overwriting the heavy failure removes its whole weight
+	cw.AddFailure(0.25)
+	if w := cw.WeightedFailures(); w != 1.25 {
+		t.Errorf("Weighted failures should be 1.25, got %v", w)
+	}
+
+	cw.Reset()
+	if w := cw.WeightedFailures(); w != 0 {
+		t.Errorf("After reset, weighted failures should be 0, got %v", w)
+	}
+}
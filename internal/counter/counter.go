@@ -1,30 +1,104 @@
 package counter
 
 import (
+	"math"
+	"math/rand/v2"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/nutcase/gomian/internal/clock"
 )
 
+// Window is a sliding window of request outcomes used for failure rate calculation.
+type Window interface {
+	// IncrementSuccess records a successful request.
+	IncrementSuccess()
+	// IncrementFailure records a failed request.
+	IncrementFailure()
+	// AddFailure records a failed request weighing weight. IncrementFailure
+	// is equivalent to AddFailure(1).
+	AddFailure(weight float64)
+	// Counts returns the number of requests and failures currently in the window.
+	Counts() (requests, failures uint64)
+	// WeightedFailures returns the sum of the weights of the failures currently in the window.
+	WeightedFailures() float64
+	// Reset clears the window.
+	Reset()
+}
+
+// Failure weights are stored as fixed-point integers of weightScale units, so
+// that they can be accumulated atomically. Weights are accurate to 1/weightScale.
+const weightScale = 1000
+
+// toWeightUnits converts a failure weight to fixed-point units. Every failure
+// weighs at least one unit, so that a recorded failure is never invisible.
+func toWeightUnits(weight float64) uint64 {
+	units := math.Round(weight * weightScale)
+	if units < 1 {
+		return 1
+	}
+	if units > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint64(units)
+}
+
+// fromWeightUnits converts fixed-point units back to a failure weight.
+func fromWeightUnits(units uint64) float64 {
+	return float64(units) / weightScale
+}
+
+// numStripes is the number of independent counters each bucket is split into.
+// Writers pick a stripe at random so that concurrent increments rarely touch
+// the same cache line. It must be a power of two.
+const numStripes = 4
+
 // RollingWindow represents a rolling window counter for tracking events over time.
+//
+// Time is divided into epochs of bucketSize, counted from the creation of the
+// window. The head bucket for epoch e is buckets[e % numBuckets]; as time moves
+// on the head advances around the ring. Each bucket remembers the epoch it
+// holds, so a bucket left over from an earlier lap of the ring is recognized
+// as stale and cleared before being reused, and is never counted. The window
+// therefore always covers exactly the last numBuckets epochs, including the
+// current, partially elapsed one.
+//
+// Increments are lock-free: each bucket is striped across several padded
+// atomic counters. The mutex is only taken when a stale bucket is recycled
+// or the window is reset.
 type RollingWindow struct {
-	mu            sync.Mutex
-	buckets       []bucket
-	bucketSize    time.Duration
-	numBuckets    int
-	windowSize    time.Duration
-	lastRotation  time.Time
-	totalRequests uint64
-	totalFailures uint64
+	mu         sync.Mutex
+	clock      clock.Clock
+	buckets    []bucket
+	bucketSize time.Duration
+	numBuckets int
+	windowSize time.Duration
+	start      time.Time
 }
 
 // bucket represents a time bucket in the rolling window.
 type bucket struct {
-	requests uint64
-	failures uint64
+	epoch   atomic.Int64 // epoch held by the bucket, or -1 if empty
+	stripes [numStripes]stripe
+}
+
+// stripe is one shard of a bucket, padded to its own cache line.
+type stripe struct {
+	requests atomic.Uint64
+	failures atomic.Uint64
+	weight   atomic.Uint64 // sum of failure weights in weightScale units
+	_        [40]byte
 }
 
 // NewRollingWindow creates a new RollingWindow with the specified window size and number of buckets.
 func NewRollingWindow(windowSize time.Duration, numBuckets int) *RollingWindow {
+	return NewRollingWindowWithClock(windowSize, numBuckets, clock.Real())
+}
+
+// NewRollingWindowWithClock creates a new RollingWindow that reads the time from clk.
+func NewRollingWindowWithClock(windowSize time.Duration, numBuckets int, clk clock.Clock) *RollingWindow {
+	clk = clock.OrReal(clk)
 	if numBuckets <= 0 {
 		numBuckets = 10 // Default to 10 buckets
 	}
@@ -34,82 +108,103 @@ func NewRollingWindow(windowSize time.Duration, numBuckets int) *RollingWindow {
 		bucketSize = time.Millisecond
 	}
 	
-	buckets := make([]bucket, numBuckets)
-	
-	return &RollingWindow{
-		buckets:      buckets,
-		bucketSize:   bucketSize,
-		numBuckets:   numBuckets,
-		windowSize:   windowSize,
-		lastRotation: time.Now(),
+	rw := &RollingWindow{
+		clock:      clk,
+		buckets:    make([]bucket, numBuckets),
+		bucketSize: bucketSize,
+		numBuckets: numBuckets,
+		windowSize: windowSize,
+		start:      clk.Now(),
+	}
+	for i := range rw.buckets {
+		rw.buckets[i].epoch.Store(-1)
 	}
+	return rw
 }
 
-// rotate rotates the buckets if necessary based on the current time.
-func (rw *RollingWindow) rotate() {
-	now := time.Now()
-	elapsed := now.Sub(rw.lastRotation)
-	
-	if elapsed < rw.bucketSize {
-		return
-	}
-	
-	// Calculate how many buckets to rotate
-	bucketsToRotate := int(elapsed / rw.bucketSize)
-	if bucketsToRotate > rw.numBuckets {
-		bucketsToRotate = rw.numBuckets
+// epoch returns the index of the bucket-sized interval containing the current time.
+func (rw *RollingWindow) epoch() int64 {
+	elapsed := rw.clock.Now().Sub(rw.start)
+	if elapsed < 0 {
+		return 0
 	}
-	
-	// Rotate the buckets
-	for i := 0; i < bucketsToRotate; i++ {
-		// Remove the oldest bucket's counts from the totals
-		oldestBucket := (i + 1) % rw.numBuckets
-		rw.totalRequests -= rw.buckets[oldestBucket].requests
-		rw.totalFailures -= rw.buckets[oldestBucket].failures
-		
-		// Reset the bucket
-		rw.buckets[oldestBucket].requests = 0
-		rw.buckets[oldestBucket].failures = 0
+	return int64(elapsed / rw.bucketSize)
+}
+
+// head returns the bucket for the current epoch, recycling it first if it
+// still holds an older epoch.
+func (rw *RollingWindow) head() *bucket {
+	epoch := rw.epoch()
+	b := &rw.buckets[epoch%int64(rw.numBuckets)]
+
+	if b.epoch.Load() != epoch {
+		rw.mu.Lock()
+		// Another goroutine may have recycled the bucket while we were waiting for the lock
+		if b.epoch.Load() != epoch {
+			b.reset()
+			b.epoch.Store(epoch)
+		}
+		rw.mu.Unlock()
 	}
-	
-	// Update the last rotation time
-	rw.lastRotation = now.Add(-elapsed % rw.bucketSize)
+	return b
+}
+
+// current returns a stripe of the head bucket.
+func (rw *RollingWindow) current() *stripe {
+	return &rw.head().stripes[rand.Uint32()&(numStripes-1)]
 }
 
 // IncrementSuccess increments the success counter.
 func (rw *RollingWindow) IncrementSuccess() {
-	rw.mu.Lock()
-	defer rw.mu.Unlock()
-	
-	rw.rotate()
-	
-	currentBucket := 0 // Always use the current bucket (index 0)
-	rw.buckets[currentBucket].requests++
-	rw.totalRequests++
+	rw.current().requests.Add(1)
 }
 
 // IncrementFailure increments the failure counter.
 func (rw *RollingWindow) IncrementFailure() {
-	rw.mu.Lock()
-	defer rw.mu.Unlock()
-	
-	rw.rotate()
-	
-	currentBucket := 0 // Always use the current bucket (index 0)
-	rw.buckets[currentBucket].requests++
-	rw.buckets[currentBucket].failures++
-	rw.totalRequests++
-	rw.totalFailures++
+	rw.AddFailure(1)
+}
+
+// AddFailure increments the failure counter and adds weight to the failure weight.
+func (rw *RollingWindow) AddFailure(weight float64) {
+	s := rw.current()
+	s.requests.Add(1)
+	s.failures.Add(1)
+	s.weight.Add(toWeightUnits(weight))
 }
 
 // Counts returns the total number of requests and failures in the window.
 func (rw *RollingWindow) Counts() (requests, failures uint64) {
-	rw.mu.Lock()
-	defer rw.mu.Unlock()
-	
-	rw.rotate()
-	
-	return rw.totalRequests, rw.totalFailures
+	epoch := rw.epoch()
+	oldest := epoch - int64(rw.numBuckets) + 1
+
+	for i := range rw.buckets {
+		b := &rw.buckets[i]
+		if e := b.epoch.Load(); e < oldest || e > epoch {
+			continue
+		}
+		r, f := b.counts()
+		requests += r
+		failures += f
+	}
+	return requests, failures
+}
+
+// WeightedFailures returns the sum of the weights of the failures in the window.
+func (rw *RollingWindow) WeightedFailures() float64 {
+	epoch := rw.epoch()
+	oldest := epoch - int64(rw.numBuckets) + 1
+
+	var units uint64
+	for i := range rw.buckets {
+		b := &rw.buckets[i]
+		if e := b.epoch.Load(); e < oldest || e > epoch {
+			continue
+		}
+		for j := range b.stripes {
+			units += b.stripes[j].weight.Load()
+		}
+	}
+	return fromWeightUnits(units)
 }
 
 // Reset resets all counters to zero.
@@ -118,22 +213,42 @@ func (rw *RollingWindow) Reset() {
 	defer rw.mu.Unlock()
 	
 	for i := range rw.buckets {
-		rw.buckets[i].requests = 0
-		rw.buckets[i].failures = 0
+		rw.buckets[i].epoch.Store(-1)
+		rw.buckets[i].reset()
+	}
+}
+
+// counts sums the stripes of the bucket.
+func (b *bucket) counts() (requests, failures uint64) {
+	for i := range b.stripes {
+		requests += b.stripes[i].requests.Load()
+		failures += b.stripes[i].failures.Load()
+	}
+	return requests, failures
+}
+
+// reset zeroes every stripe of the bucket.
+func (b *bucket) reset() {
+	for i := range b.stripes {
+		b.stripes[i].requests.Store(0)
+		b.stripes[i].failures.Store(0)
+		b.stripes[i].weight.Store(0)
 	}
-	
-	rw.totalRequests = 0
-	rw.totalFailures = 0
-	rw.lastRotation = time.Now()
 }
 
 // ConsecutiveCounter tracks consecutive successes or failures.
+//
+// All counters are atomics, so recording an outcome never takes a lock.
+// An increment and the reset of the opposite streak are two separate atomic
+// operations; under concurrent mixed outcomes the streaks are therefore
+// approximate, which is acceptable since the outcomes themselves race.
 type ConsecutiveCounter struct {
-	mu                 sync.Mutex
-	consecutiveSuccess uint64
-	consecutiveFailure uint64
-	totalSuccess       uint64
-	totalFailure       uint64
+	consecutiveSuccess atomic.Uint64
+	consecutiveFailure atomic.Uint64
+	consecutiveWeight  atomic.Uint64 // failure weight of the current streak in weightScale units
+	totalSuccess       atomic.Uint64
+	totalFailure       atomic.Uint64
+	totalWeight        atomic.Uint64 // total failure weight in weightScale units
 }
 
 // NewConsecutiveCounter creates a new ConsecutiveCounter.
@@ -143,55 +258,59 @@ func NewConsecutiveCounter() *ConsecutiveCounter {
 
 // IncrementSuccess increments the success counter and resets the failure counter.
 func (cc *ConsecutiveCounter) IncrementSuccess() {
-	cc.mu.Lock()
-	defer cc.mu.Unlock()
-	
-	cc.consecutiveSuccess++
-	cc.consecutiveFailure = 0
-	cc.totalSuccess++
+	cc.consecutiveSuccess.Add(1)
+	cc.consecutiveFailure.Store(0)
+	cc.consecutiveWeight.Store(0)
+	cc.totalSuccess.Add(1)
 }
 
 // IncrementFailure increments the failure counter and resets the success counter.
 func (cc *ConsecutiveCounter) IncrementFailure() {
-	cc.mu.Lock()
-	defer cc.mu.Unlock()
-	
-	cc.consecutiveFailure++
-	cc.consecutiveSuccess = 0
-	cc.totalFailure++
+	cc.AddFailure(1)
+}
+
+// AddFailure increments the failure counter, adds weight to the failure
+// weight and resets the success counter.
+func (cc *ConsecutiveCounter) AddFailure(weight float64) {
+	units := toWeightUnits(weight)
+	cc.consecutiveFailure.Add(1)
+	cc.consecutiveWeight.Add(units)
+	cc.consecutiveSuccess.Store(0)
+	cc.totalFailure.Add(1)
+	cc.totalWeight.Add(units)
 }
 
 // ConsecutiveSuccesses returns the number of consecutive successes.
 func (cc *ConsecutiveCounter) ConsecutiveSuccesses() uint64 {
-	cc.mu.Lock()
-	defer cc.mu.Unlock()
-	
-	return cc.consecutiveSuccess
+	return cc.consecutiveSuccess.Load()
 }
 
 // ConsecutiveFailures returns the number of consecutive failures.
 func (cc *ConsecutiveCounter) ConsecutiveFailures() uint64 {
-	cc.mu.Lock()
-	defer cc.mu.Unlock()
-	
-	return cc.consecutiveFailure
+	return cc.consecutiveFailure.Load()
+}
+
+// ConsecutiveFailureWeight returns the sum of the weights of the consecutive failures.
+func (cc *ConsecutiveCounter) ConsecutiveFailureWeight() float64 {
+	return fromWeightUnits(cc.consecutiveWeight.Load())
+}
+
+// TotalFailureWeight returns the sum of the weights of all failures.
+func (cc *ConsecutiveCounter) TotalFailureWeight() float64 {
+	return fromWeightUnits(cc.totalWeight.Load())
 }
 
 // Totals returns the total number of successes and failures.
 func (cc *ConsecutiveCounter) Totals() (successes, failures uint64) {
-	cc.mu.Lock()
-	defer cc.mu.Unlock()
-	
-	return cc.totalSuccess, cc.totalFailure
+	return cc.totalSuccess.Load(), cc.totalFailure.Load()
 }
 
 // Reset resets all counters to zero.
 func (cc *ConsecutiveCounter) Reset() {
-	cc.mu.Lock()
-	defer cc.mu.Unlock()
-	
-	cc.consecutiveSuccess = 0
-	cc.consecutiveFailure = 0
-	cc.totalSuccess = 0
-	cc.totalFailure = 0
+	cc.consecutiveSuccess.Store(0)
+	cc.consecutiveFailure.Store(0)
+	cc.consecutiveWeight.Store(0)
+	cc.totalSuccess.Store(0)
+	cc.totalFailure.Store(0)
+	cc.totalWeight.Store(0)
 }
@@ -1,8 +1,11 @@
 package counter
 
 import (
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/nutcase/gomian/clocktest"
 )
 
 func TestConsecutiveCounter(t *testing.T) {
@@ -106,9 +109,53 @@ func TestRollingWindow(t *testing.T) {
 	}
 }
 
+func TestWeightedFailures(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	rw := NewRollingWindowWithClock(time.Second, 10, clk)
+	cc := NewConsecutiveCounter()
+
+	for _, weight := range []float64{2, 0.5, 1} {
+		rw.AddFailure(weight)
+		cc.AddFailure(weight)
+	}
+	if w := rw.WeightedFailures(); w != 3.5 {
+		t.Errorf("Window weighted failures should be 3.5, got %v", w)
+	}
+	if w := cc.ConsecutiveFailureWeight(); w != 3.5 {
+		t.Errorf("Consecutive failure weight should be 3.5, got %v", w)
+	}
+	if requests, failures := rw.Counts(); requests != 3 || failures != 3 {
+		t.Errorf("Weights should not change the counts, got %d requests and %d failures", requests, failures)
+	}
+
+	// A success ends the streak but not the total
+	cc.IncrementSuccess()
+	if w := cc.ConsecutiveFailureWeight(); w != 0 {
+		t.Errorf("Consecutive failure weight should be reset by a success, got %v", w)
+	}
+	if w := cc.TotalFailureWeight(); w != 3.5 {
+		t.Errorf("Total failure weight should be 3.5, got %v", w)
+	}
+
+	// Weights expire with their bucket
+	clk.Advance(time.Second)
+	rw.AddFailure(0.25)
+	if w := rw.WeightedFailures(); w != 0.25 {
+		t.Errorf("Expired weights should not be counted, got %v", w)
+	}
+
+	// Even a negligible failure weighs something
+	cc.Reset()
+	cc.AddFailure(0)
+	if w := cc.ConsecutiveFailureWeight(); w <= 0 {
+		t.Errorf("A failure should always have a positive weight, got %v", w)
+	}
+}
+
 func TestRollingWindowRotation(t *testing.T) {
 	// Create a rolling window with a 100ms window size and 2 buckets
-	rw := NewRollingWindow(100*time.Millisecond, 2)
+	clk := clocktest.NewFakeClock(time.Now())
+	rw := NewRollingWindowWithClock(100*time.Millisecond, 2, clk)
 	
 	// Add some events
 	rw.IncrementSuccess()
@@ -123,7 +170,7 @@ func TestRollingWindowRotation(t *testing.T) {
 	}
 	
 	// Wait for more than one bucket duration but less than the full window
-	time.Sleep(60 * time.Millisecond)
+	clk.Advance(60 * time.Millisecond)
 	
 	// Add more events
 	rw.IncrementSuccess()
@@ -137,7 +184,7 @@ func TestRollingWindowRotation(t *testing.T) {
 	}
 	
 	// Wait for the full window to expire
-	time.Sleep(110 * time.Millisecond)
+	clk.Advance(110 * time.Millisecond)
 	
 	// Check counts after full window expiration
 	requests, failures = rw.Counts()
@@ -146,3 +193,50 @@ func TestRollingWindowRotation(t *testing.T) {
 			requests, failures)
 	}
 }
+
+func TestRollingWindowConcurrent(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	rw := NewRollingWindowWithClock(time.Second, 10, clk)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if i%2 == 0 {
+					rw.IncrementFailure()
+				} else {
+					rw.IncrementSuccess()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	requests, failures := rw.Counts()
+	if requests != 8000 || failures != 4000 {
+		t.Errorf("Should have 8000 requests and 4000 failures, got %d requests and %d failures",
+			requests, failures)
+	}
+}
+
+func BenchmarkRollingWindowIncrement(b *testing.B) {
+	rw := NewRollingWindow(10*time.Second, 10)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rw.IncrementSuccess()
+		}
+	})
+}
+
+func BenchmarkConsecutiveCounterIncrement(b *testing.B) {
+	cc := NewConsecutiveCounter()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cc.IncrementSuccess()
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package counter
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nutcase/gomian/internal/clock"
+)
+
+// EWMA tracks an exponentially weighted moving average of request outcomes.
+//
+// Every recorded request contributes to two decayed sums, one of all requests
+// and one of failures, and both lose half their weight every halfLife. The
+// failure score is their ratio. Unlike a bucketed window, old outcomes fade
+// out gradually instead of dropping out all at once when their bucket expires.
+// The decayed request sum is the effective volume the score is based on.
+type EWMA struct {
+	mu       sync.Mutex
+	clock    clock.Clock
+	halfLife time.Duration
+	requests float64
+	failures float64
+	last     time.Time
+}
+
+// NewEWMA creates a new EWMA whose outcomes lose half their weight every halfLife.
+func NewEWMA(halfLife time.Duration, clk clock.Clock) *EWMA {
+	clk = clock.OrReal(clk)
+	if halfLife <= 0 {
+		halfLife = time.Second
+	}
+
+	return &EWMA{
+		clock:    clk,
+		halfLife: halfLife,
+		last:     clk.Now(),
+	}
+}
+
+// decay returns the factor by which weights recorded at e.last have decayed by now.
+func (e *EWMA) decay(now time.Time) float64 {
+	elapsed := now.Sub(e.last)
+	if elapsed <= 0 {
+		return 1
+	}
+	return math.Exp2(-float64(elapsed) / float64(e.halfLife))
+}
+
+// add decays the sums to the current time and records one request.
+func (e *EWMA) add(failure float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.clock.Now()
+	factor := e.decay(now)
+	e.requests = e.requests*factor + 1
+	e.failures = e.failures*factor + failure
+	if now.After(e.last) {
+		e.last = now
+	}
+}
+
+// IncrementSuccess records a successful request.
+func (e *EWMA) IncrementSuccess() {
+	e.add(0)
+}
+
+// IncrementFailure records a failed request.
+func (e *EWMA) IncrementFailure() {
+	e.add(1)
+}
+
+// AddFailure records a failed request weighing weight. Weights above 1 can
+// push the score above 1.
+func (e *EWMA) AddFailure(weight float64) {
+	e.add(weight)
+}
+
+// Score returns the failure score and the decayed request volume it is based
+// on. The score is between 0 and 1 unless failures weigh more than 1, and is
+// zero if nothing was recorded.
+func (e *EWMA) Score() (score, volume float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.requests == 0 {
+		return 0, 0
+	}
+	// Decay affects both sums equally, so it only changes the volume
+	return e.failures / e.requests, e.requests * e.decay(e.clock.Now())
+}
+
+// Reset clears the recorded outcomes.
+func (e *EWMA) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.requests = 0
+	e.failures = 0
+	e.last = e.clock.Now()
+}
@@ -0,0 +1,72 @@
+package counter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nutcase/gomian/clocktest"
+)
+
+func TestEWMA(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	e := NewEWMA(10*time.Second, clk)
+
+	// Test initial state
+	if score, volume := e.Score(); score != 0 || volume != 0 {
+		t.Errorf("Initial score and volume should be 0, got %v and %v", score, volume)
+	}
+
+	// Outcomes recorded at the same instant weigh the same
+	e.IncrementFailure()
+	e.IncrementSuccess()
+	e.IncrementSuccess()
+	e.IncrementSuccess()
+	if score, volume := e.Score(); score != 0.25 || volume != 4 {
+		t.Errorf("Score should be 0.25 over 4 requests, got %v over %v", score, volume)
+	}
+
+	// After one half-life the volume halves but the score stays
+	clk.Advance(10 * time.Second)
+	if score, volume := e.Score(); score != 0.25 || volume != 2 {
+		t.Errorf("Score should be 0.25 over 2 requests, got %v over %v", score, volume)
+	}
+
+	// New outcomes outweigh the decayed ones
+	e.IncrementFailure()
+	e.IncrementFailure()
+	if score, volume := e.Score(); math.Abs(score-2.5/4) > 1e-9 || volume != 4 {
+		t.Errorf("Score should be 0.625 over 4 requests, got %v over %v", score, volume)
+	}
+
+	// Test reset
+	e.Reset()
+	if score, volume := e.Score(); score != 0 || volume != 0 {
+		t.Errorf("After reset, score and volume should be 0, got %v and %v", score, volume)
+	}
+}
+
+func TestEWMANoCliff(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	e := NewEWMA(time.Second, clk)
+
+	// A burst of failures followed by steady successes
+	for i := 0; i < 10; i++ {
+		e.IncrementFailure()
+	}
+
+	previous, _ := e.Score()
+	for i := 0; i < 50; i++ {
+		clk.Advance(100 * time.Millisecond)
+		e.IncrementSuccess()
+
+		score, _ := e.Score()
+		if score >= previous {
+			t.Fatalf("Score should decrease steadily, went from %v to %v", previous, score)
+		}
+		if previous-score > 0.1 {
+			t.Fatalf("Score should not drop sharply, went from %v to %v", previous, score)
+		}
+		previous = score
+	}
+}
@@ -0,0 +1,233 @@
+package counter
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nutcase/gomian/internal/clock"
+)
+
+// Latency buckets are log-linear over microseconds: durations below
+// linearLimit microseconds get one bucket per microsecond, and every power of
+// two above that is split into subBuckets equal parts, giving a relative
+// error of at most 1/subBuckets. Durations of 2^maxExponent microseconds
+// (about 9.5 hours) and above share the last bucket.
+const (
+	subBucketBits     = 3
+	subBuckets        = 1 << subBucketBits
+	linearLimit       = 2 * subBuckets
+	minExponent       = subBucketBits + 1
+	maxExponent       = 35
+	numLatencyBuckets = linearLimit + (maxExponent-minExponent+1)*subBuckets
+)
+
+// LatencyHistogram is a rolling histogram of call durations.
+//
+// It is split into time slots in the same way as RollingWindow: each slot
+// remembers the epoch it holds and is cleared before being reused, so
+// percentiles always reflect the last numSlots slots. Recording is lock-free.
+type LatencyHistogram struct {
+	mu       sync.Mutex
+	clock    clock.Clock
+	slots    []histogramSlot
+	slotSize time.Duration
+	numSlots int
+	start    time.Time
+}
+
+// histogramSlot holds the bucket counts of one time slot.
+type histogramSlot struct {
+	epoch  atomic.Int64 // epoch held by the slot, or -1 if empty
+	counts [numLatencyBuckets]atomic.Uint64
+}
+
+// NewLatencyHistogram creates a LatencyHistogram covering windowSize, split into numSlots slots.
+func NewLatencyHistogram(windowSize time.Duration, numSlots int, clk clock.Clock) *LatencyHistogram {
+	clk = clock.OrReal(clk)
+	if numSlots <= 0 {
+		numSlots = 10 // Default to 10 slots
+	}
+
+	slotSize := windowSize / time.Duration(numSlots)
+	if slotSize < time.Millisecond {
+		slotSize = time.Millisecond
+	}
+
+	h := &LatencyHistogram{
+		clock:    clk,
+		slots:    make([]histogramSlot, numSlots),
+		slotSize: slotSize,
+		numSlots: numSlots,
+		start:    clk.Now(),
+	}
+	for i := range h.slots {
+		h.slots[i].epoch.Store(-1)
+	}
+	return h
+}
+
+// epoch returns the index of the slot-sized interval containing the current time.
+func (h *LatencyHistogram) epoch() int64 {
+	elapsed := h.clock.Now().Sub(h.start)
+	if elapsed < 0 {
+		return 0
+	}
+	return int64(elapsed / h.slotSize)
+}
+
+// head returns the slot for the current epoch, recycling it first if it
+// still holds an older epoch.
+func (h *LatencyHistogram) head() *histogramSlot {
+	epoch := h.epoch()
+	s := &h.slots[epoch%int64(h.numSlots)]
+
+	if s.epoch.Load() != epoch {
+		h.mu.Lock()
+		// Another goroutine may have recycled the slot while we were waiting for the lock
+		if s.epoch.Load() != epoch {
+			s.reset()
+			s.epoch.Store(epoch)
+		}
+		h.mu.Unlock()
+	}
+	return s
+}
+
+// Record adds a duration to the histogram.
+func (h *LatencyHistogram) Record(d time.Duration) {
+	h.head().counts[latencyBucket(d)].Add(1)
+}
+
+// snapshot merges the counts of every slot within the window.
+func (h *LatencyHistogram) snapshot() (counts [numLatencyBuckets]uint64, total uint64) {
+	epoch := h.epoch()
+	oldest := epoch - int64(h.numSlots) + 1
+
+	for i := range h.slots {
+		s := &h.slots[i]
+		if e := s.epoch.Load(); e < oldest || e > epoch {
+			continue
+		}
+		for j := range s.counts {
+			c := s.counts[j].Load()
+			counts[j] += c
+			total += c
+		}
+	}
+	return counts, total
+}
+
+// Count returns the number of durations recorded within the window.
+func (h *LatencyHistogram) Count() uint64 {
+	_, total := h.snapshot()
+	return total
+}
+
+// Percentile returns an estimate of the p-th percentile (0 < p <= 100) of the
+// durations recorded within the window, together with the number of samples
+// it is based on. The estimate is interpolated within the bucket holding the
+// requested rank. It returns zero if no samples were recorded.
+func (h *LatencyHistogram) Percentile(p float64) (time.Duration, uint64) {
+	counts, total := h.snapshot()
+	if total == 0 {
+		return 0, 0
+	}
+	return percentile(&counts, total, p), total
+}
+
+// Percentiles returns estimates for several percentiles computed from a
+// single snapshot of the window, together with the number of samples.
+func (h *LatencyHistogram) Percentiles(ps ...float64) ([]time.Duration, uint64) {
+	counts, total := h.snapshot()
+	values := make([]time.Duration, len(ps))
+	if total == 0 {
+		return values, 0
+	}
+
+	for i, p := range ps {
+		values[i] = percentile(&counts, total, p)
+	}
+	return values, total
+}
+
+// Reset clears the histogram.
+func (h *LatencyHistogram) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := range h.slots {
+		h.slots[i].epoch.Store(-1)
+		h.slots[i].reset()
+	}
+}
+
+// reset zeroes every bucket of the slot.
+func (s *histogramSlot) reset() {
+	for i := range s.counts {
+		s.counts[i].Store(0)
+	}
+}
+
+// percentile finds the p-th percentile within merged bucket counts.
+func percentile(counts *[numLatencyBuckets]uint64, total uint64, p float64) time.Duration {
+	if p <= 0 {
+		p = 0
+	}
+	if p > 100 {
+		p = 100
+	}
+
+	// rank is the 1-based position of the requested sample, using the
+	// nearest-rank method so that high percentiles are never underestimated
+	rank := uint64(math.Ceil(p / 100 * float64(total)))
+	rank = min(max(rank, 1), total)
+
+	var seen uint64
+	for i, c := range counts {
+		if c == 0 || seen+c < rank {
+			seen += c
+			continue
+		}
+		lower, upper := latencyBucketBounds(i)
+		fraction := float64(rank-seen) / float64(c)
+		us := float64(lower) + fraction*float64(upper-lower)
+		return time.Duration(us * float64(time.Microsecond))
+	}
+
+	_, upper := latencyBucketBounds(numLatencyBuckets - 1)
+	return time.Duration(upper) * time.Microsecond
+}
+
+// latencyBucket returns the bucket index for a duration.
+func latencyBucket(d time.Duration) int {
+	if d < 0 {
+		d = 0
+	}
+	us := uint64(d / time.Microsecond)
+	if us < linearLimit {
+		return int(us)
+	}
+
+	exponent := bits.Len64(us) - 1
+	if exponent > maxExponent {
+		return numLatencyBuckets - 1
+	}
+	sub := int(us>>(exponent-subBucketBits)) & (subBuckets - 1)
+	return linearLimit + (exponent-minExponent)*subBuckets + sub
+}
+
+// latencyBucketBounds returns the range of microseconds [lower, upper) covered by a bucket.
+func latencyBucketBounds(i int) (lower, upper uint64) {
+	if i < linearLimit {
+		return uint64(i), uint64(i) + 1
+	}
+
+	exponent := (i-linearLimit)/subBuckets + minExponent
+	sub := uint64((i - linearLimit) % subBuckets)
+	width := uint64(1) << (exponent - subBucketBits)
+	lower = uint64(1)<<exponent + sub*width
+	return lower, lower + width
+}
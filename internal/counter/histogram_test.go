@@ -0,0 +1,150 @@
+package counter
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/nutcase/gomian/clocktest"
+)
+
+func TestLatencyBucketBounds(t *testing.T) {
+	// Every bucket must contain the durations it is chosen for
+	for _, us := range []uint64{0, 1, 15, 16, 17, 31, 32, 100, 999, 1000, 123456, 1 << 30} {
+		i := latencyBucket(time.Duration(us) * time.Microsecond)
+		lower, upper := latencyBucketBounds(i)
+		if us < lower || us >= upper {
+			t.Errorf("%dus should fall in bucket %d [%d, %d)", us, i, lower, upper)
+		}
+	}
+
+	// Buckets are contiguous
+	for i := 1; i < numLatencyBuckets; i++ {
+		_, prevUpper := latencyBucketBounds(i - 1)
+		lower, _ := latencyBucketBounds(i)
+		if lower != prevUpper {
+			t.Fatalf("Bucket %d should start at %d, got %d", i, prevUpper, lower)
+		}
+	}
+
+	// Very long durations are clamped to the last bucket
+	if latencyBucket(1000*time.Hour) != numLatencyBuckets-1 {
+		t.Error("Durations beyond the range should fall in the last bucket")
+	}
+}
+
+func TestLatencyHistogramPercentiles(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	h := NewLatencyHistogram(10*time.Second, 10, clk)
+
+	// Test empty histogram
+	if d, n := h.Percentile(99); d != 0 || n != 0 {
+		t.Errorf("Empty histogram should report 0, got %v with %d samples", d, n)
+	}
+
+	// Record 1ms..1000ms in random order
+	rng := rand.New(rand.NewSource(1))
+	var samples []time.Duration
+	for i := 1; i <= 1000; i++ {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	rng.Shuffle(len(samples), func(i, j int) { samples[i], samples[j] = samples[j], samples[i] })
+	for _, d := range samples {
+		h.Record(d)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	for _, p := range []float64{50, 95, 99} {
+		got, n := h.Percentile(p)
+		if n != 1000 {
+			t.Errorf("Should have 1000 samples, got %d", n)
+		}
+
+		want := samples[int(math.Ceil(p/100*float64(len(samples))))-1]
+		if diff := got - want; diff > want/subBuckets || -diff > want/subBuckets {
+			t.Errorf("p%v should be within %v of %v, got %v", p, want/subBuckets, want, got)
+		}
+	}
+
+	values, n := h.Percentiles(50, 99)
+	if len(values) != 2 || values[0] >= values[1] {
+		t.Errorf("Percentiles should be increasing, got %v", values)
+	}
+	if n != 1000 {
+		t.Errorf("Percentiles should be based on 1000 samples, got %d", n)
+	}
+}
+
+func TestLatencyHistogramSmallSample(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	h := NewLatencyHistogram(10*time.Second, 10, clk)
+
+	h.Record(0)
+	h.Record(time.Second)
+
+	// With two samples, the median is the first and higher percentiles the second
+	tests := map[float64]time.Duration{50: 0, 95: time.Second, 99: time.Second}
+	for p, want := range tests {
+		got, _ := h.Percentile(p)
+		if diff := got - want; diff > want/subBuckets+time.Microsecond || -diff > want/subBuckets+time.Microsecond {
+			t.Errorf("p%v should be about %v, got %v", p, want, got)
+		}
+	}
+
+	// p99 of fewer than 100 samples is the largest sample
+	for i := 0; i < 8; i++ {
+		h.Record(time.Millisecond)
+	}
+	if got, n := h.Percentile(99); n != 10 || got < time.Second-time.Second/subBuckets {
+		t.Errorf("p99 of 10 samples should be the largest one of 1s, got %v with %d samples", got, n)
+	}
+}
+
+func TestLatencyHistogramRolling(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	h := NewLatencyHistogram(time.Second, 10, clk)
+
+	for i := 0; i < 10; i++ {
+		h.Record(time.Second)
+	}
+
+	// Faster samples recorded later in the window
+	clk.Advance(500 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		h.Record(time.Millisecond)
+	}
+
+	if h.Count() != 20 {
+		t.Errorf("Should have 20 samples, got %d", h.Count())
+	}
+
+	// Once the slow samples rotate out, only the fast ones remain
+	clk.Advance(600 * time.Millisecond)
+	d, n := h.Percentile(99)
+	if n != 10 {
+		t.Errorf("Should have 10 samples after rotation, got %d", n)
+	}
+	if d > 2*time.Millisecond {
+		t.Errorf("p99 should only reflect the fast samples, got %v", d)
+	}
+
+	// Test reset
+	h.Reset()
+	if h.Count() != 0 {
+		t.Errorf("After reset, should have 0 samples, got %d", h.Count())
+	}
+}
+
+func BenchmarkLatencyHistogramRecord(b *testing.B) {
+	h := NewLatencyHistogram(10*time.Second, 10, nil)
+
+	b.RunParallel(func(pb *testing.PB) {
+		d := time.Duration(0)
+		for pb.Next() {
+			d += time.Microsecond
+			h.Record(d)
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package counter
+
+import (
+	"math/rand"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/nutcase/gomian/clocktest"
+)
+
+// modelEvent is a single recorded outcome in the brute-force model.
+type modelEvent struct {
+	at     time.Time
+	failed bool
+}
+
+// windowModel is a brute-force reference for RollingWindow that keeps every
+// event and decides at query time which ones fall within the window.
+type windowModel struct {
+	start      time.Time
+	bucketSize time.Duration
+	numBuckets int64
+	events     []modelEvent
+}
+
+// epoch returns the bucket-sized interval containing t.
+func (m *windowModel) epoch(t time.Time) int64 {
+	return int64(t.Sub(m.start) / m.bucketSize)
+}
+
+// counts returns the requests and failures whose epoch is one of the last
+// numBuckets epochs at now.
+func (m *windowModel) counts(now time.Time) (requests, failures uint64) {
+	current := m.epoch(now)
+	for _, e := range m.events {
+		if current-m.epoch(e.at) >= m.numBuckets {
+			continue
+		}
+		requests++
+		if e.failed {
+			failures++
+		}
+	}
+	return requests, failures
+}
+
+// checkRandomTimeline replays a random timeline generated from seed against
+// both a RollingWindow and the brute-force model, comparing counts after
+// every step.
+func checkRandomTimeline(t *testing.T, seed int64) bool {
+	rng := rand.New(rand.NewSource(seed))
+
+	numBuckets := 1 + rng.Intn(12)
+	windowSize := time.Duration(1+rng.Intn(2000)) * time.Millisecond
+	start := time.Unix(0, rng.Int63n(int64(time.Hour))).UTC()
+
+	clk := clocktest.NewFakeClock(start)
+	rw := NewRollingWindowWithClock(windowSize, numBuckets, clk)
+	model := &windowModel{
+		start:      start,
+		bucketSize: rw.bucketSize,
+		numBuckets: int64(numBuckets),
+	}
+
+	// Steps range from bursts within one bucket to gaps longer than the window
+	maxStep := int64(rw.bucketSize) * int64(numBuckets+2)
+
+	for step := 0; step < 300; step++ {
+		switch r := rng.Intn(100); {
+		case r < 40:
+			rw.IncrementSuccess()
+			model.events = append(model.events, modelEvent{at: clk.Now()})
+		case r < 70:
+			rw.IncrementFailure()
+			model.events = append(model.events, modelEvent{at: clk.Now(), failed: true})
+		case r < 98:
+			if rng.Intn(4) == 0 {
+				clk.Advance(time.Duration(rng.Int63n(maxStep)))
+			} else {
+				clk.Advance(time.Duration(rng.Int63n(int64(rw.bucketSize))))
+			}
+		default:
+			rw.Reset()
+			model.events = nil
+		}
+
+		gotRequests, gotFailures := rw.Counts()
+		wantRequests, wantFailures := model.counts(clk.Now())
+		if gotRequests != wantRequests || gotFailures != wantFailures {
+			t.Logf("seed %d, step %d (%d buckets of %v): got %d requests and %d failures, want %d requests and %d failures",
+				seed, step, numBuckets, rw.bucketSize, gotRequests, gotFailures, wantRequests, wantFailures)
+			return false
+		}
+	}
+	return true
+}
+
+func TestRollingWindowMatchesModel(t *testing.T) {
+	property := func(seed int64) bool {
+		return checkRandomTimeline(t, seed)
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRollingWindowMatchesModelAcrossLaps(t *testing.T) {
+	// Events spaced exactly one full window apart land in the same ring slot
+	// and must never be counted together.
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	rw := NewRollingWindowWithClock(time.Second, 10, clk)
+
+	for i := 0; i < 5; i++ {
+		rw.IncrementFailure()
+
+		requests, failures := rw.Counts()
+		if requests != 1 || failures != 1 {
+			t.Fatalf("Lap %d should count only the latest failure, got %d requests and %d failures",
+				i, requests, failures)
+		}
+
+		clk.Advance(time.Second)
+	}
+}
+
+func TestRollingWindowExpiresBucketByBucket(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	rw := NewRollingWindowWithClock(time.Second, 10, clk)
+
+	// One failure in each of the 10 buckets
+	for i := 0; i < 10; i++ {
+		rw.IncrementFailure()
+		clk.Advance(100 * time.Millisecond)
+	}
+
+	// Every further bucket drops exactly the oldest failure
+	for want := uint64(9); ; want-- {
+		if _, failures := rw.Counts(); failures != want {
+			t.Fatalf("Should have %d failures, got %d", want, failures)
+		}
+		if want == 0 {
+			break
+		}
+		clk.Advance(100 * time.Millisecond)
+	}
+}
@@ -2,7 +2,10 @@ package state_machine
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/nutcase/gomian/internal/clock"
 )
 
 // State represents the possible states of a circuit breaker.
@@ -19,28 +22,59 @@ const (
 	HalfOpen
 )
 
+// stateBits is the number of low bits of the state word holding the State.
+// The remaining high bits hold the generation.
+const stateBits = 2
+
+// stateMask extracts the State from a state word.
+const stateMask = 1<<stateBits - 1
+
 // StateMachine manages the state transitions of a circuit breaker.
+//
+// The current state and its generation are packed into a single atomic word so
+// that reading them never takes a lock. The generation is incremented on every
+// transition, which lets callers detect that the state changed underneath them
+// even if it later returned to the same value. Transitions themselves are
+// serialized by mu so that state change callbacks run in order.
 type StateMachine struct {
 	mu             sync.Mutex
-	state          State
+	clock          clock.Clock
+	word           atomic.Uint64
 	lastStateChange time.Time
 	onStateChange  func(from, to State)
 }
 
 // NewStateMachine creates a new StateMachine with the initial state set to Closed.
 func NewStateMachine(onStateChange func(from, to State)) *StateMachine {
-	return &StateMachine{
-		state:          Closed,
-		lastStateChange: time.Now(),
+	return NewStateMachineWithClock(clock.Real(), onStateChange)
+}
+
+// NewStateMachineWithClock creates a new StateMachine that reads the time from clk.
+func NewStateMachineWithClock(clk clock.Clock, onStateChange func(from, to State)) *StateMachine {
+	clk = clock.OrReal(clk)
+	sm := &StateMachine{
+		clock:          clk,
+		lastStateChange: clk.Now(),
 		onStateChange:  onStateChange,
 	}
+	sm.word.Store(uint64(Closed))
+	return sm
 }
 
 // State returns the current state of the circuit breaker.
 func (sm *StateMachine) State() State {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-	return sm.state
+	return State(sm.word.Load() & stateMask)
+}
+
+// Generation returns the number of transitions made since the state machine was created.
+func (sm *StateMachine) Generation() uint64 {
+	return sm.word.Load() >> stateBits
+}
+
+// Snapshot returns the current state together with its generation, read atomically.
+func (sm *StateMachine) Snapshot() (State, uint64) {
+	w := sm.word.Load()
+	return State(w & stateMask), w >> stateBits
 }
 
 // LastStateChange returns the time of the last state change.
@@ -52,56 +86,58 @@ func (sm *StateMachine) LastStateChange() time.Time {
 
 // TransitionToOpen transitions the circuit breaker to the Open state.
 func (sm *StateMachine) TransitionToOpen() {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-
-	if sm.state == Open {
-		return
-	}
-
-	oldState := sm.state
-	sm.state = Open
-	sm.lastStateChange = time.Now()
-
-	if sm.onStateChange != nil {
-		sm.onStateChange(oldState, Open)
-	}
+	sm.transition(Open)
 }
 
 // TransitionToHalfOpen transitions the circuit breaker to the HalfOpen state.
 func (sm *StateMachine) TransitionToHalfOpen() {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
+	sm.transition(HalfOpen)
+}
 
-	if sm.state == HalfOpen {
-		return
-	}
+// TransitionToClosed transitions the circuit breaker to the Closed state.
+func (sm *StateMachine) TransitionToClosed() {
+	sm.transition(Closed)
+}
 
-	oldState := sm.state
-	sm.state = HalfOpen
-	sm.lastStateChange = time.Now()
+// CompareAndTransition transitions to the given state only if the generation
+// is still the given one, i.e. no other transition happened since the caller
+// observed it. It reports whether the transition was made.
+func (sm *StateMachine) CompareAndTransition(generation uint64, to State) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 
-	if sm.onStateChange != nil {
-		sm.onStateChange(oldState, HalfOpen)
+	if sm.word.Load()>>stateBits != generation {
+		return false
 	}
+	return sm.transitionLocked(to)
 }
 
-// TransitionToClosed transitions the circuit breaker to the Closed state.
-func (sm *StateMachine) TransitionToClosed() {
+// transition moves the state machine to the given state and bumps the generation.
+// It is a no-op if the state machine is already in that state.
+func (sm *StateMachine) transition(to State) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	if sm.state == Closed {
-		return
+	sm.transitionLocked(to)
+}
+
+// transitionLocked performs a transition with mu held and reports whether the
+// state changed.
+func (sm *StateMachine) transitionLocked(to State) bool {
+	w := sm.word.Load()
+	oldState := State(w & stateMask)
+	if oldState == to {
+		return false
 	}
 
-	oldState := sm.state
-	sm.state = Closed
-	sm.lastStateChange = time.Now()
+	generation := w>>stateBits + 1
+	sm.word.Store(generation<<stateBits | uint64(to))
+	sm.lastStateChange = sm.clock.Now()
 
 	if sm.onStateChange != nil {
-		sm.onStateChange(oldState, Closed)
+		sm.onStateChange(oldState, to)
 	}
+	return true
 }
 
 // IsOpen returns true if the circuit breaker is in the Open state.
@@ -121,5 +157,5 @@ func (sm *StateMachine) IsClosed() bool {
 
 // TimeInState returns the duration since the last state change.
 func (sm *StateMachine) TimeInState() time.Duration {
-	return time.Since(sm.LastStateChange())
+	return sm.clock.Since(sm.LastStateChange())
 }
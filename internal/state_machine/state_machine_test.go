@@ -3,6 +3,8 @@ package state_machine
 import (
 	"testing"
 	"time"
+
+	"github.com/nutcase/gomian/clocktest"
 )
 
 func TestNewStateMachine(t *testing.T) {
@@ -148,10 +150,11 @@ func TestStateCheckers(t *testing.T) {
 }
 
 func TestLastStateChange(t *testing.T) {
-	sm := NewStateMachine(nil)
+	clk := clocktest.NewFakeClock(time.Now())
+	sm := NewStateMachineWithClock(clk, nil)
 	initialTime := sm.LastStateChange()
 	
-	time.Sleep(10 * time.Millisecond)
+	clk.Advance(10 * time.Millisecond)
 	
 	// Transition to Open
 	sm.TransitionToOpen()
@@ -162,8 +165,8 @@ func TestLastStateChange(t *testing.T) {
 		t.Error("LastStateChange should be updated after state transition")
 	}
 	
-	// Sleep again
-	time.Sleep(10 * time.Millisecond)
+	// Advance again
+	clk.Advance(10 * time.Millisecond)
 	
 	// Transition to HalfOpen
 	sm.TransitionToHalfOpen()
@@ -176,13 +179,78 @@ func TestLastStateChange(t *testing.T) {
 }
 
 func TestTimeInState(t *testing.T) {
-	sm := NewStateMachine(nil)
+	clk := clocktest.NewFakeClock(time.Now())
+	sm := NewStateMachineWithClock(clk, nil)
 	
-	// Sleep a bit
-	time.Sleep(10 * time.Millisecond)
+	// Advance a bit
+	clk.Advance(10 * time.Millisecond)
 	
-	// Check that time in state is positive
-	if sm.TimeInState() <= 0 {
-		t.Error("TimeInState should be positive")
+	// Check that time in state matches the elapsed time
+	if sm.TimeInState() != 10*time.Millisecond {
+		t.Errorf("TimeInState should be 10ms, got %v", sm.TimeInState())
+	}
+}
+
+func TestGeneration(t *testing.T) {
+	sm := NewStateMachine(nil)
+
+	if sm.Generation() != 0 {
+		t.Errorf("Initial generation should be 0, got %d", sm.Generation())
+	}
+
+	sm.TransitionToOpen()
+	sm.TransitionToHalfOpen()
+	sm.TransitionToClosed()
+
+	state, generation := sm.Snapshot()
+	if state != Closed {
+		t.Errorf("State should be Closed, got %v", state)
+	}
+	if generation != 3 {
+		t.Errorf("Generation should be 3 after three transitions, got %d", generation)
+	}
+
+	// A no-op transition does not bump the generation
+	sm.TransitionToClosed()
+	if sm.Generation() != 3 {
+		t.Errorf("Generation should stay 3 after a no-op transition, got %d", sm.Generation())
+	}
+}
+
+func BenchmarkState(b *testing.B) {
+	sm := NewStateMachine(nil)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = sm.State()
+		}
+	})
+}
+
+func TestCompareAndTransition(t *testing.T) {
+	sm := NewStateMachine(nil)
+	generation := sm.Generation()
+
+	sm.TransitionToOpen()
+
+	// A stale generation is rejected
+	if sm.CompareAndTransition(generation, Closed) {
+		t.Error("CompareAndTransition should fail with a stale generation")
+	}
+	if sm.State() != Open {
+		t.Errorf("State should remain Open, got %v", sm.State())
+	}
+
+	// The current generation is accepted
+	if !sm.CompareAndTransition(sm.Generation(), HalfOpen) {
+		t.Error("CompareAndTransition should succeed with the current generation")
+	}
+	if sm.State() != HalfOpen {
+		t.Errorf("State should be HalfOpen, got %v", sm.State())
+	}
+
+	// Transitioning to the current state is a no-op
+	if sm.CompareAndTransition(sm.Generation(), HalfOpen) {
+		t.Error("CompareAndTransition to the current state should report no change")
 	}
 }
@@ -0,0 +1,232 @@
+package gomian
+
+import (
+	"time"
+)
+
+// Option configures a circuit breaker created with New.
+type Option func(*Settings)
+
+// New creates a CircuitBreaker named name, starting from DefaultSettings and
+// applying opts in order. The resulting settings are validated as a whole, so
+// an option that only makes sense together with another one is checked once
+// all options have been applied.
+func New(name string, opts ...Option) (*CircuitBreaker, error) {
+	settings := DefaultSettings()
+	settings.Name = name
+
+	for _, opt := range opts {
+		opt(&settings)
+	}
+
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+
+	return NewCircuitBreaker(settings), nil
+}
+
+// WithConsecutiveFailures trips the circuit after threshold consecutive failures.
+func WithConsecutiveFailures(threshold uint64) Option {
+	return func(s *Settings) {
+		s.FailureThreshold = ConsecutiveFailures(threshold)
+	}
+}
+
+// WithFailureRate trips the circuit when the failure rate within window reaches rate,
+// once at least minimumRequests requests have been observed in that window.
+func WithFailureRate(rate float64, minimumRequests uint64, window time.Duration) Option {
+	return func(s *Settings) {
+		s.FailureThreshold = NewFailureRateThreshold(rate, minimumRequests)
+		s.MinimumRequestVolume = minimumRequests
+		s.WindowType = TimeWindow
+		s.RollingWindow = window
+	}
+}
+
+// WithCountWindow trips the circuit when the failure rate among the last size
+// requests reaches rate, once at least minimumRequests requests have been recorded.
+func WithCountWindow(rate float64, minimumRequests uint64, size uint64) Option {
+	return func(s *Settings) {
+		s.FailureThreshold = NewFailureRateThreshold(rate, minimumRequests)
+		s.MinimumRequestVolume = minimumRequests
+		s.WindowType = CountWindow
+		s.WindowCount = size
+	}
+}
+
+// WithLatencyThreshold trips the circuit when the given latency percentile
+// over the latency window exceeds limit, once at least samples calls have been timed.
+func WithLatencyThreshold(percentile float64, limit time.Duration, samples uint64) Option {
+	return func(s *Settings) {
+		s.FailureThreshold = NewLatencyThreshold(percentile, limit, samples)
+	}
+}
+
+// WithFailureScore trips the circuit when the exponentially weighted failure
+// score reaches score, once the decayed request volume is at least minimumVolume.
+// Outcomes lose half their weight every halfLife.
+func WithFailureScore(score, minimumVolume float64, halfLife time.Duration) Option {
+	return func(s *Settings) {
+		s.FailureThreshold = NewFailureScoreThreshold(score, minimumVolume)
+		s.FailureScoreHalfLife = halfLife
+	}
+}
+
+// WithSuccessThreshold sets the number of consecutive successes required to close from Half-Open.
+func WithSuccessThreshold(threshold uint64) Option {
+	return func(s *Settings) {
+		s.SuccessThreshold = threshold
+	}
+}
+
+// WithHalfOpenMaxRequests sets how many probe requests are admitted
+// concurrently in the Half-Open state.
+func WithHalfOpenMaxRequests(n uint64) Option {
+	return func(s *Settings) {
+		s.HalfOpenMaxRequests = n
+	}
+}
+
+// WithBulkhead limits the number of concurrent calls with the given bulkhead.
+// If countAsFailure is set, calls it rejects count as failures.
+func WithBulkhead(bulkhead *Bulkhead, countAsFailure bool) Option {
+	return func(s *Settings) {
+		s.Bulkhead = bulkhead
+		s.BulkheadFailures = countAsFailure
+	}
+}
+
+// WithTimeout sets how long the circuit stays Open before transitioning to Half-Open.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *Settings) {
+		s.Timeout = timeout
+	}
+}
+
+// WithResetTimeout enables resetting the failure counters in the Closed state
+// after a period without failures.
+func WithResetTimeout(timeout time.Duration) Option {
+	return func(s *Settings) {
+		s.ResetTimeout = timeout
+	}
+}
+
+// WithIsFailure sets a custom function to determine if an error counts as a failure.
+func WithIsFailure(isFailure func(error) bool) Option {
+	return func(s *Settings) {
+		s.IsFailure = isFailure
+	}
+}
+
+// WithClassifier sets a function determining the Outcome of each call.
+func WithClassifier(classify func(error) Outcome) Option {
+	return func(s *Settings) {
+		s.Classify = classify
+	}
+}
+
+// WithOutcomes adds outcomes for the errors matched by each entry; the first
+// matching entry determines the outcome.
+func WithOutcomes(outcomes ...ErrorOutcome) Option {
+	return func(s *Settings) {
+		s.Outcomes = append(s.Outcomes, outcomes...)
+	}
+}
+
+// WithCallTimeout bounds the duration of each call; calls that overrun it
+// return ErrCallTimeout and count as failures.
+func WithCallTimeout(timeout time.Duration) Option {
+	return func(s *Settings) {
+		s.CallTimeout = timeout
+	}
+}
+
+// WithAbandonOnTimeout returns to the caller as soon as a call's context is
+// done, leaving the operation to finish in the background.
+func WithAbandonOnTimeout() Option {
+	return func(s *Settings) {
+		s.AbandonOnTimeout = true
+	}
+}
+
+// WithPanicPolicy selects whether panics in the operation are propagated
+// or returned as a *PanicError once recorded as failures.
+func WithPanicPolicy(policy PanicPolicy) Option {
+	return func(s *Settings) {
+		s.PanicPolicy = policy
+	}
+}
+
+// WithContextErrors selects how context.Canceled and context.DeadlineExceeded
+// are counted when they come from the caller's context and when they come
+// from the dependency.
+func WithContextErrors(caller, dependency ContextErrorPolicy) Option {
+	return func(s *Settings) {
+		s.CallerContextErrors = caller
+		s.DependencyContextErrors = dependency
+	}
+}
+
+// WithIgnoredErrors adds errors that are not counted at all.
+func WithIgnoredErrors(errs ...error) Option {
+	return func(s *Settings) {
+		s.IgnoredErrors = append(s.IgnoredErrors, errs...)
+	}
+}
+
+// WithFailureWeights adds weight classes for failures; the first matching
+// class determines how much a failure counts towards the failure threshold.
+func WithFailureWeights(weights ...ErrorWeight) Option {
+	return func(s *Settings) {
+		s.FailureWeights = append(s.FailureWeights, weights...)
+	}
+}
+
+// WithFailureWeightFunc sets a function returning how much a failure counts
+// towards the failure threshold.
+func WithFailureWeightFunc(weight func(error) float64) Option {
+	return func(s *Settings) {
+		s.FailureWeight = weight
+	}
+}
+
+// WithLatencyWindow records call durations over window, split into buckets
+// time slots, and reports latency percentiles in Metrics.
+func WithLatencyWindow(window time.Duration, buckets int) Option {
+	return func(s *Settings) {
+		s.LatencyWindow = window
+		s.LatencyWindowBuckets = buckets
+	}
+}
+
+// WithLogger sets the logger used for diagnostic messages.
+func WithLogger(logger Logger) Option {
+	return func(s *Settings) {
+		s.Logger = logger
+	}
+}
+
+// WithClock sets the clock used by the circuit breaker, its counters and timers.
+func WithClock(clock Clock) Option {
+	return func(s *Settings) {
+		s.Clock = clock
+	}
+}
+
+// WithLazyTransitions evaluates the Open timeout and the reset timeout lazily
+// on the next call instead of using background timers.
+func WithLazyTransitions() Option {
+	return func(s *Settings) {
+		s.LazyTransitions = true
+	}
+}
+
+// WithRampUp admits a growing fraction of requests for duration after the
+// circuit closes from Half-Open, following strategy.
+func WithRampUp(duration time.Duration, strategy RampUpStrategy) Option {
+	return func(s *Settings) {
+		s.RampUp = duration
+		s.RampUpStrategy = strategy
+	}
+}
@@ -0,0 +1,133 @@
+package gomian
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewWithDefaults(t *testing.T) {
+	cb, err := New("TestBreaker")
+	if err != nil {
+		t.Fatalf("New should succeed with defaults, got error: %v", err)
+	}
+	defer cb.Close()
+
+	if cb.Name() != "TestBreaker" {
+		t.Errorf("Name should be 'TestBreaker', got '%s'", cb.Name())
+	}
+
+	defaults := DefaultSettings()
+	if cb.settings.Timeout != defaults.Timeout {
+		t.Errorf("Timeout should default to %v, got %v", defaults.Timeout, cb.settings.Timeout)
+	}
+	if cb.settings.SuccessThreshold != defaults.SuccessThreshold {
+		t.Errorf("SuccessThreshold should default to %d, got %d", defaults.SuccessThreshold, cb.settings.SuccessThreshold)
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	ignoredErr := errors.New("ignored error")
+	cb, err := New("TestBreaker",
+		WithFailureRate(0.5, 10, 30*time.Second),
+		WithSuccessThreshold(3),
+		WithHalfOpenMaxRequests(4),
+		WithTimeout(2*time.Second),
+		WithIgnoredErrors(ignoredErr),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got error: %v", err)
+	}
+	defer cb.Close()
+
+	threshold, ok := cb.settings.FailureThreshold.(FailureRateThreshold)
+	if !ok {
+		t.Fatalf("FailureThreshold should be FailureRateThreshold, got %T", cb.settings.FailureThreshold)
+	}
+	if threshold.Rate != 0.5 {
+		t.Errorf("Rate should be 0.5, got %v", threshold.Rate)
+	}
+	if cb.settings.MinimumRequestVolume != 10 {
+		t.Errorf("MinimumRequestVolume should be 10, got %d", cb.settings.MinimumRequestVolume)
+	}
+	if cb.settings.RollingWindow != 30*time.Second {
+		t.Errorf("RollingWindow should be 30s, got %v", cb.settings.RollingWindow)
+	}
+	if cb.settings.SuccessThreshold != 3 {
+		t.Errorf("SuccessThreshold should be 3, got %d", cb.settings.SuccessThreshold)
+	}
+	if cb.settings.HalfOpenMaxRequests != 4 {
+		t.Errorf("HalfOpenMaxRequests should be 4, got %d", cb.settings.HalfOpenMaxRequests)
+	}
+	if cb.settings.Timeout != 2*time.Second {
+		t.Errorf("Timeout should be 2s, got %v", cb.settings.Timeout)
+	}
+	if cb.rollingWindow == nil {
+		t.Error("Rolling window should be initialized for a failure rate threshold")
+	}
+	if cb.isFailure(ignoredErr) {
+		t.Error("Ignored error should not count as a failure")
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"zero consecutive failures", []Option{WithConsecutiveFailures(0)}},
+		{"failure rate above one", []Option{WithFailureRate(1.5, 10, time.Second)}},
+		{"failure rate without window", []Option{WithFailureRate(0.5, 10, 0)}},
+		{"zero timeout", []Option{WithTimeout(0)}},
+		{"zero success threshold", []Option{WithSuccessThreshold(0)}},
+		{"negative reset timeout", []Option{WithResetTimeout(-time.Second)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb, err := New("TestBreaker", tt.opts...)
+			if err == nil {
+				cb.Close()
+				t.Fatal("New should fail validation")
+			}
+			if !errors.Is(err, ErrInvalidSettings) {
+				t.Errorf("Error should wrap ErrInvalidSettings, got: %v", err)
+			}
+		})
+	}
+
+	// Several problems are reported together
+	_, err := New("", WithTimeout(0), WithSuccessThreshold(0))
+	if err == nil {
+		t.Fatal("New should fail validation")
+	}
+	for _, want := range []string{"name", "timeout", "success threshold"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Error should mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	cb, err := New("TestBreaker",
+		WithConsecutiveFailures(1),
+		WithTimeout(time.Hour),
+		WithLogger(log.New(&buf, "", 0)),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got error: %v", err)
+	}
+	defer cb.Close()
+
+	cb.Execute(func() error {
+		return errors.New("failure")
+	})
+
+	if !strings.Contains(buf.String(), "Closed -> Open") {
+		t.Errorf("Logger should record the state change, got: %q", buf.String())
+	}
+}
@@ -0,0 +1,175 @@
+package gomian
+
+import (
+	"errors"
+	"fmt"
+)
+
+// OutcomeKind determines how an Outcome affects a circuit breaker's counters.
+type OutcomeKind int
+
+const (
+	// KindSuccess outcomes count as successful requests.
+	KindSuccess OutcomeKind = iota
+
+	// KindFailure outcomes count as failed requests and may trip the circuit.
+	KindFailure
+
+	// KindIgnore outcomes are not counted at all: they neither add to the
+	// request volume nor affect the consecutive streaks or latency.
+	KindIgnore
+
+	// KindSlow outcomes are not counted as successes or failures, but their
+	// duration is recorded, so they can trip a latency threshold.
+	KindSlow
+)
+
+// String returns a string representation of the OutcomeKind.
+func (k OutcomeKind) String() string {
+	switch k {
+	case KindSuccess:
+		return "Success"
+	case KindFailure:
+		return "Failure"
+	case KindIgnore:
+		return "Ignore"
+	case KindSlow:
+		return "Slow"
+	default:
+		return fmt.Sprintf("Unknown OutcomeKind(%d)", k)
+	}
+}
+
+// Outcome classifies the result of a call. Besides the predefined Success,
+// Failure, Ignore and Slow outcomes, custom outcomes can be declared so that they
+// are counted separately in Metrics.Outcomes, e.g.
+//
+//	var Throttled = gomian.Outcome{Name: "Throttled", Kind: gomian.KindIgnore}
+type Outcome struct {
+	// Name identifies the outcome in Metrics.Outcomes and callbacks.
+	Name string
+	// Kind determines how the outcome is counted.
+	Kind OutcomeKind
+	// Weight is how much a failure outcome counts towards the failure
+	// threshold. If zero, Settings.FailureWeight or FailureWeights decide.
+	Weight float64
+}
+
+// The predefined outcomes.
+var (
+	Success = Outcome{Name: "Success", Kind: KindSuccess}
+	Failure = Outcome{Name: "Failure", Kind: KindFailure}
+	Ignore  = Outcome{Name: "Ignore", Kind: KindIgnore}
+	Slow    = Outcome{Name: "Slow", Kind: KindSlow}
+)
+
+// String returns the name of the Outcome.
+func (o Outcome) String() string {
+	return o.Name
+}
+
+// ContextErrorSource tells where a context.Canceled or context.DeadlineExceeded
+// error returned by an operation came from. It is the Name of the Outcome
+// such errors are classified as.
+type ContextErrorSource int
+
+const (
+	// CallerContext means the context passed to ExecuteContext was canceled or
+	// its deadline expired: the caller gave up on the call.
+	CallerContext ContextErrorSource = iota
+
+	// DependencyContext means the operation returned a context error while the
+	// caller's context was still live, e.g. from a deadline set by the
+	// dependency's client library.
+	DependencyContext
+
+	// CallTimeoutContext means the call overran Settings.CallTimeout. Such
+	// calls return ErrCallTimeout and always count as failures.
+	CallTimeoutContext
+)
+
+// String returns a string representation of the ContextErrorSource.
+func (c ContextErrorSource) String() string {
+	switch c {
+	case CallerContext:
+		return "CallerContext"
+	case DependencyContext:
+		return "DependencyContext"
+	case CallTimeoutContext:
+		return "CallTimeout"
+	default:
+		return fmt.Sprintf("Unknown ContextErrorSource(%d)", c)
+	}
+}
+
+// ContextErrorPolicy selects how context errors from a given source are counted.
+type ContextErrorPolicy int
+
+const (
+	// ContextErrorDefault ignores context errors from the caller and classifies
+	// others like any other error.
+	ContextErrorDefault ContextErrorPolicy = iota
+
+	// ContextErrorIgnore does not count the call at all.
+	ContextErrorIgnore
+
+	// ContextErrorFailure counts the call as a failure.
+	ContextErrorFailure
+
+	// ContextErrorSlow counts the call as slow: its duration is recorded for
+	// latency thresholds, but it is neither a success nor a failure.
+	ContextErrorSlow
+)
+
+// String returns a string representation of the ContextErrorPolicy.
+func (c ContextErrorPolicy) String() string {
+	switch c {
+	case ContextErrorDefault:
+		return "Default"
+	case ContextErrorIgnore:
+		return "Ignore"
+	case ContextErrorFailure:
+		return "Failure"
+	case ContextErrorSlow:
+		return "Slow"
+	default:
+		return fmt.Sprintf("Unknown ContextErrorPolicy(%d)", c)
+	}
+}
+
+// ErrorOutcome assigns an outcome to the errors matched by Match.
+type ErrorOutcome struct {
+	// Match reports whether an error has this outcome.
+	// ErrorIs and ErrorAs build common matchers.
+	Match func(error) bool
+	// Outcome is the outcome of a matched error.
+	Outcome Outcome
+}
+
+// defaultOutcome classifies err using the IsFailure predicate and the list
+// of ignored errors, which are matched with errors.Is. A nil error is a
+// success, and an error the predicate rejects is a success as well, since
+// the dependency handled the call.
+func defaultOutcome(err error, isFailure func(error) bool, ignored []error) Outcome {
+	if err == nil {
+		return Success
+	}
+
+	// If a custom IsFailure function is provided, use it
+	if isFailure != nil {
+		if isFailure(err) {
+			return Failure
+		}
+		return Success
+	}
+
+	// Check if the error is in the ignored errors list
+	for _, ignoredErr := range ignored {
+		if errors.Is(err, ignoredErr) {
+			return Ignore
+		}
+	}
+
+	// By default, any non-nil error is a failure
+	return Failure
+}
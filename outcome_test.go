@@ -0,0 +1,349 @@
+package gomian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nutcase/gomian/clocktest"
+)
+
+func TestOutcomeKindString(t *testing.T) {
+	tests := []struct {
+		kind     OutcomeKind
+		expected string
+	}{
+		{KindSuccess, "Success"},
+		{KindFailure, "Failure"},
+		{KindIgnore, "Ignore"},
+		{OutcomeKind(99), "Unknown OutcomeKind(99)"},
+	}
+
+	for _, tt := range tests {
+		if tt.kind.String() != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, tt.kind.String())
+		}
+	}
+}
+
+func TestDefaultOutcome(t *testing.T) {
+	ignoredErr := errors.New("ignored")
+	critical := errors.New("critical")
+
+	tests := []struct {
+		name      string
+		err       error
+		isFailure func(error) bool
+		expected  Outcome
+	}{
+		{"nil error", nil, nil, Success},
+		{"plain error", errors.New("failure"), nil, Failure},
+		{"ignored error", ignoredErr, nil, Ignore},
+		{"wrapped ignored error", fmt.Errorf("call: %w", ignoredErr), nil, Ignore},
+		{"predicate failure", critical, func(err error) bool { return err == critical }, Failure},
+		{"predicate non-failure", errors.New("minor"), func(err error) bool { return err == critical }, Success},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := defaultOutcome(tt.err, tt.isFailure, []error{ignoredErr})
+			if got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerOutcomes(t *testing.T) {
+	notFound := errors.New("not found")
+	canceled := errors.New("canceled by caller")
+	throttled := Outcome{Name: "Throttled", Kind: KindIgnore}
+
+	cb, err := New("TestBreaker",
+		WithConsecutiveFailures(3),
+		WithOutcomes(
+			ErrorOutcome{Match: ErrorIs(notFound), Outcome: Success},
+			ErrorOutcome{Match: ErrorIs(ErrThrottled), Outcome: throttled},
+		),
+		WithIgnoredErrors(canceled),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	var successes, failures, ignored int
+	seen := make(map[string]int)
+	cb.OnSuccess(func(name string) { successes++ })
+	cb.OnFailure(func(name string, err error) { failures++ })
+	cb.OnIgnored(func(name string, err error) { ignored++ })
+	cb.OnOutcome(func(name string, outcome Outcome, err error) { seen[outcome.Name]++ })
+
+	testErr := errors.New("failure")
+	results := []error{
+		nil,
+		fmt.Errorf("lookup: %w", notFound),
+		testErr,
+		fmt.Errorf("call: %w", canceled),
+		testErr,
+		fmt.Errorf("upstream: %w", ErrThrottled),
+		nil,
+	}
+	for _, result := range results {
+		if err := cb.Execute(func() error { return result }); err != result {
+			t.Errorf("Should return the operation's error %v, got %v", result, err)
+		}
+	}
+
+	if successes != 3 || failures != 2 || ignored != 2 {
+		t.Errorf("Unexpected callback counts: successes=%d failures=%d ignored=%d", successes, failures, ignored)
+	}
+
+	metrics := cb.GetMetrics()
+	if metrics.IgnoredResults != 2 {
+		t.Errorf("IgnoredResults should be 2, got %d", metrics.IgnoredResults)
+	}
+	expected := map[string]uint64{"Success": 3, "Failure": 2, "Ignore": 1, "Throttled": 1}
+	for name, count := range expected {
+		if metrics.Outcomes[name] != count {
+			t.Errorf("Outcomes[%q] should be %d, got %d", name, count, metrics.Outcomes[name])
+		}
+		if seen[name] != int(count) {
+			t.Errorf("Outcome callback should see %q %d times, got %d", name, count, seen[name])
+		}
+	}
+
+	// Ignored outcomes are not counted, so the streak is still broken by the successes
+	if metrics.ConsecutiveFailures != 0 || metrics.TotalFailures != 2 {
+		t.Errorf("Unexpected counters: %+v", metrics)
+	}
+}
+
+func TestCircuitBreakerIgnoredOutcomeKeepsStreak(t *testing.T) {
+	ignoredErr := errors.New("ignored")
+	cb := NewCircuitBreaker(Settings{
+		Name:             "TestBreaker",
+		FailureThreshold: ConsecutiveFailures(2),
+		SuccessThreshold: 1,
+		Timeout:          DefaultSettings().Timeout,
+		IgnoredErrors:    []error{ignoredErr},
+	})
+	defer cb.Close()
+
+	testErr := errors.New("failure")
+	cb.Execute(func() error { return testErr })
+	cb.Execute(func() error { return fmt.Errorf("wrapped: %w", ignoredErr) })
+	cb.Execute(func() error { return testErr })
+
+	if cb.State() != Open {
+		t.Errorf("An ignored outcome should not break the failure streak, got %v", cb.State())
+	}
+}
+
+func TestCircuitBreakerClassifier(t *testing.T) {
+	critical := errors.New("critical")
+	cb, err := New("TestBreaker",
+		WithConsecutiveFailures(2),
+		WithClassifier(func(err error) Outcome {
+			switch {
+			case err == nil:
+				return Success
+			case errors.Is(err, critical):
+				return Outcome{Name: "Critical", Kind: KindFailure, Weight: 2}
+			default:
+				return Ignore
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	for i := 0; i < 5; i++ {
+		cb.Execute(func() error { return errors.New("noise") })
+	}
+	if cb.State() != Closed {
+		t.Fatalf("Ignored errors should not trip the circuit, got %v", cb.State())
+	}
+
+	// A single critical failure weighs enough to trip the circuit
+	cb.Execute(func() error { return critical })
+	if cb.State() != Open {
+		t.Errorf("A critical failure should trip the circuit, got %v", cb.State())
+	}
+	if metrics := cb.GetMetrics(); metrics.Outcomes["Critical"] != 1 || metrics.IgnoredResults != 5 {
+		t.Errorf("Unexpected outcome metrics: %+v", metrics)
+	}
+}
+
+func TestIsFailureFalseCountsAsSuccess(t *testing.T) {
+	cb, err := New("TestBreaker",
+		WithCountWindow(0.5, 1, 10),
+		WithIsFailure(func(err error) bool { return false }),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	cb.Execute(func() error { return errors.New("handled by the dependency") })
+
+	metrics := cb.GetMetrics()
+	if metrics.TotalRequests != 1 || metrics.TotalFailures != 0 || metrics.ConsecutiveSuccesses != 1 {
+		t.Errorf("A non-failure error should count as a success, got %+v", metrics)
+	}
+}
+
+func TestValidateOutcomes(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Outcomes = []ErrorOutcome{
+		{Outcome: Failure},
+		{Match: ErrorIs(ErrThrottled), Outcome: Outcome{Kind: KindIgnore}},
+		{Match: ErrorIs(ErrThrottled), Outcome: Outcome{Name: "Bad", Kind: OutcomeKind(7), Weight: -1}},
+	}
+
+	err := settings.Validate()
+	if !errors.Is(err, ErrInvalidSettings) {
+		t.Fatalf("Invalid outcomes should be rejected, got %v", err)
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); !ok || len(joined.Unwrap()) != 4 {
+		t.Errorf("All four problems should be reported, got %v", err)
+	}
+}
+
+func TestContextErrorStrings(t *testing.T) {
+	if CallerContext.String() != "CallerContext" || DependencyContext.String() != "DependencyContext" {
+		t.Errorf("Unexpected source names: %s, %s", CallerContext, DependencyContext)
+	}
+	if KindSlow.String() != "Slow" || ContextErrorSlow.String() != "Slow" || ContextErrorDefault.String() != "Default" {
+		t.Errorf("Unexpected names: %s, %s, %s", KindSlow, ContextErrorSlow, ContextErrorDefault)
+	}
+
+	settings := DefaultSettings()
+	settings.CallerContextErrors = ContextErrorPolicy(9)
+	if err := settings.Validate(); !errors.Is(err, ErrInvalidSettings) {
+		t.Errorf("An unknown context error policy should be invalid, got %v", err)
+	}
+}
+
+func TestCircuitBreakerCallerCancellation(t *testing.T) {
+	cb, err := New("TestBreaker", WithConsecutiveFailures(1))
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	// The caller cancels while the call is in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	err = cb.ExecuteContext(ctx, func(ctx context.Context) error {
+		cancel()
+		return fmt.Errorf("query: %w", ctx.Err())
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Should return the cancellation, got %v", err)
+	}
+	if cb.State() != Closed {
+		t.Errorf("A caller cancellation should not trip the circuit, got %v", cb.State())
+	}
+
+	metrics := cb.GetMetrics()
+	if metrics.Outcomes["CallerContext"] != 1 || metrics.IgnoredResults != 1 {
+		t.Errorf("The cancellation should be ignored as a caller context error, got %+v", metrics)
+	}
+
+	// A deadline from the dependency, with the caller still waiting, is a failure
+	cb.ExecuteContext(context.Background(), func(ctx context.Context) error {
+		return context.DeadlineExceeded
+	})
+	if cb.State() != Open {
+		t.Errorf("A dependency deadline should trip the circuit, got %v", cb.State())
+	}
+	if cb.GetMetrics().Outcomes["DependencyContext"] != 0 {
+		t.Error("By default dependency context errors should be classified like other errors")
+	}
+}
+
+func TestCircuitBreakerContextErrorPolicies(t *testing.T) {
+	cb, err := New("TestBreaker",
+		WithConsecutiveFailures(1),
+		WithContextErrors(ContextErrorFailure, ContextErrorIgnore),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	cb.ExecuteContext(context.Background(), func(ctx context.Context) error {
+		return context.DeadlineExceeded
+	})
+	if cb.State() != Closed {
+		t.Fatalf("Ignored dependency context errors should not trip the circuit, got %v", cb.State())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cb.ExecuteContext(ctx, func(ctx context.Context) error {
+		cancel()
+		return ctx.Err()
+	})
+	if cb.State() != Open {
+		t.Errorf("Caller context errors counted as failures should trip the circuit, got %v", cb.State())
+	}
+
+	metrics := cb.GetMetrics()
+	if metrics.Outcomes["DependencyContext"] != 1 || metrics.Outcomes["CallerContext"] != 1 {
+		t.Errorf("Outcomes should record the source of each context error, got %v", metrics.Outcomes)
+	}
+}
+
+func TestCircuitBreakerContextErrorSlow(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb, err := New("TestBreaker",
+		WithLatencyThreshold(50, time.Second, 2),
+		WithContextErrors(ContextErrorSlow, ContextErrorDefault),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	// Callers give up on slow calls; they count towards latency but not failures
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cb.ExecuteContext(ctx, func(ctx context.Context) error {
+			clk.Advance(2 * time.Second)
+			cancel()
+			return ctx.Err()
+		})
+	}
+
+	if cb.State() != Open {
+		t.Errorf("Slow calls should trip the latency threshold, got %v", cb.State())
+	}
+	metrics := cb.GetMetrics()
+	if metrics.TotalFailures != 0 || metrics.IgnoredResults != 0 || metrics.LatencySamples != 2 {
+		t.Errorf("Slow calls should only be counted as latency samples, got %+v", metrics)
+	}
+}
+
+func TestIgnoredOutcomeSkipsLatency(t *testing.T) {
+	ignoredErr := errors.New("ignored")
+	cb, err := New("TestBreaker",
+		WithLatencyWindow(time.Minute, 10),
+		WithIgnoredErrors(ignoredErr),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	cb.Execute(func() error { return ignoredErr })
+	cb.Execute(func() error { return nil })
+
+	if samples := cb.GetMetrics().LatencySamples; samples != 1 {
+		t.Errorf("Only the counted call should be timed, got %d samples", samples)
+	}
+}
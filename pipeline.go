@@ -0,0 +1,426 @@
+package gomian
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nutcase/gomian/internal/clock"
+)
+
+// Policy is a stage of a Pipeline. CircuitBreaker, Bulkhead, Retry,
+// AdaptiveThrottle, Timeout and Fallback are all policies.
+type Policy interface {
+	// Name identifies the stage in events and metrics.
+	Name() string
+	// ExecuteContext runs op under the policy.
+	ExecuteContext(ctx context.Context, op func(context.Context) error) error
+}
+
+// StageEventKind tells how a Pipeline stage handled a call.
+type StageEventKind int
+
+const (
+	// StageSuccess means the call succeeded through the stage.
+	StageSuccess StageEventKind = iota
+
+	// StageRejected means the stage returned an error without calling the
+	// next stage, e.g. because a circuit was open or a bulkhead full.
+	StageRejected
+
+	// StageFailed means the stage returned an error of its own after calling
+	// the next stage, e.g. because the call timed out.
+	StageFailed
+
+	// StagePropagated means the stage returned the error of the next stage unchanged.
+	StagePropagated
+
+	// StageRecovered means the next stage failed but the stage succeeded,
+	// e.g. because a retry or a fallback succeeded.
+	StageRecovered
+)
+
+// String returns a string representation of the StageEventKind.
+func (k StageEventKind) String() string {
+	switch k {
+	case StageSuccess:
+		return "Success"
+	case StageRejected:
+		return "Rejected"
+	case StageFailed:
+		return "Failed"
+	case StagePropagated:
+		return "Propagated"
+	case StageRecovered:
+		return "Recovered"
+	default:
+		return fmt.Sprintf("Unknown StageEventKind(%d)", k)
+	}
+}
+
+// StageEvent describes how a Pipeline stage handled a call. A stage below a
+// Retry emits an event per attempt.
+type StageEvent struct {
+	// Pipeline is the name of the pipeline.
+	Pipeline string
+	// Stage is the name of the stage, and Index its position in the pipeline.
+	Stage string
+	Index int
+	// Kind tells how the stage handled the call.
+	Kind StageEventKind
+	// Err is the error the stage returned, if any.
+	Err error
+	// Duration is how long the call took through the stage.
+	Duration time.Duration
+}
+
+// StageEventCallback is a function that is called when a Pipeline stage has handled a call.
+type StageEventCallback func(event StageEvent)
+
+// StageMetrics counts how a Pipeline stage handled calls, including each
+// attempt of a retried call.
+type StageMetrics struct {
+	Name       string
+	Calls      uint64
+	Rejections uint64
+	Failures   uint64
+	Propagated uint64
+	Recoveries uint64
+}
+
+// PipelineMetrics represents the current metrics of a Pipeline.
+type PipelineMetrics struct {
+	Name     string
+	Calls    uint64
+	Failures uint64
+
+	// RejectedBy and FailedBy count failed calls by the name of the stage the
+	// returned error came from. OperationFailures counts those whose error
+	// came from the operation itself.
+	RejectedBy        map[string]uint64
+	FailedBy          map[string]uint64
+	OperationFailures uint64
+
+	// Stages holds the metrics of each stage, outermost first.
+	Stages []StageMetrics
+}
+
+// stageCounters holds the counters of a Pipeline stage.
+type stageCounters struct {
+	calls      atomic.Uint64
+	rejections atomic.Uint64
+	failures   atomic.Uint64
+	propagated atomic.Uint64
+	recoveries atomic.Uint64
+
+	// Calls whose returned error came from this stage
+	rejectedCalls atomic.Uint64
+	failedCalls   atomic.Uint64
+}
+
+// Pipeline composes policies around an operation. The first stage is the
+// outermost one: it sees every call, and each stage runs the rest of the
+// pipeline as its operation, passing its context on. For example
+//
+//	gomian.NewPipeline("MyService", fallback, retry, cb, bulkhead, timeout)
+//
+// falls back once retries are exhausted, retries calls through the circuit
+// breaker, and bounds each attempt with a timeout once it got a bulkhead slot.
+type Pipeline struct {
+	name              string
+	stages            []Policy
+	stats             []stageCounters
+	calls             atomic.Uint64
+	failures          atomic.Uint64
+	operationFailures atomic.Uint64
+	clock             clock.Clock
+	onStageEvent      []StageEventCallback
+}
+
+// NewPipeline creates a new Pipeline running calls through the given stages,
+// outermost first. Stage names should be unique within a pipeline.
+func NewPipeline(name string, stages ...Policy) *Pipeline {
+	return NewPipelineWithClock(name, nil, stages...)
+}
+
+// NewPipelineWithClock creates a new Pipeline that measures stage durations
+// with clk. If clk is nil, the real clock is used.
+func NewPipelineWithClock(name string, clk Clock, stages ...Policy) *Pipeline {
+	if name == "" {
+		name = "default"
+	}
+
+	return &Pipeline{
+		name:   name,
+		stages: stages,
+		stats:  make([]stageCounters, len(stages)),
+		clock:  clock.OrReal(clk),
+	}
+}
+
+// pipelineCall tracks where the error of a call through a Pipeline came from.
+type pipelineCall struct {
+	mu     sync.Mutex
+	origin int // index of the stage the error came from, or -1 for the operation
+	kind   StageEventKind
+}
+
+// setOrigin records the stage the call's error currently comes from.
+func (c *pipelineCall) setOrigin(stage int, kind StageEventKind) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.origin, c.kind = stage, kind
+}
+
+// Execute executes the given function through the pipeline.
+func (p *Pipeline) Execute(op func() error) error {
+	return p.ExecuteContext(context.Background(), func(ctx context.Context) error {
+		return op()
+	})
+}
+
+// ExecuteContext executes the given function with context through the pipeline.
+func (p *Pipeline) ExecuteContext(ctx context.Context, op func(context.Context) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	call := &pipelineCall{origin: -1}
+	err := p.run(ctx, 0, op, call)
+
+	p.calls.Add(1)
+	if err == nil {
+		return nil
+	}
+	p.failures.Add(1)
+
+	call.mu.Lock()
+	origin, kind := call.origin, call.kind
+	call.mu.Unlock()
+
+	switch {
+	case origin < 0:
+		p.operationFailures.Add(1)
+	case kind == StageRejected:
+		p.stats[origin].rejectedCalls.Add(1)
+	default:
+		p.stats[origin].failedCalls.Add(1)
+	}
+	return err
+}
+
+// run runs op through the stages from the given index on.
+func (p *Pipeline) run(ctx context.Context, index int, op func(context.Context) error, call *pipelineCall) error {
+	if index == len(p.stages) {
+		err := op(ctx)
+		if err != nil {
+			call.setOrigin(-1, StageFailed)
+		}
+		return err
+	}
+
+	// The next stage may still be running when this one returns, if it was
+	// abandoned, so what it reports is guarded by a mutex
+	var mu sync.Mutex
+	var called bool
+	var nextErr error
+
+	stage := p.stages[index]
+	start := p.clock.Now()
+	err := stage.ExecuteContext(ctx, func(ctx context.Context) error {
+		mu.Lock()
+		called = true
+		mu.Unlock()
+
+		err := p.run(ctx, index+1, op, call)
+		mu.Lock()
+		nextErr = err
+		mu.Unlock()
+		return err
+	})
+	duration := p.clock.Since(start)
+
+	mu.Lock()
+	wasCalled, lastErr := called, nextErr
+	mu.Unlock()
+
+	var kind StageEventKind
+	switch {
+	case err == nil && lastErr == nil:
+		kind = StageSuccess
+	case err == nil:
+		kind = StageRecovered
+	case !wasCalled:
+		kind = StageRejected
+	case !sameError(err, lastErr):
+		kind = StageFailed
+	default:
+		kind = StagePropagated
+	}
+	if kind == StageRejected || kind == StageFailed {
+		call.setOrigin(index, kind)
+	}
+
+	p.count(index, kind)
+	p.notifyStageEvent(StageEvent{
+		Pipeline: p.name,
+		Stage:    stage.Name(),
+		Index:    index,
+		Kind:     kind,
+		Err:      err,
+		Duration: duration,
+	})
+	return err
+}
+
+// sameError reports whether a and b are the same error value. Unlike ==, it
+// does not panic on errors of an uncomparable type, which it never considers the same.
+func sameError(a, b error) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t != nil && t.Comparable() && a == b
+}
+
+// notifyStageEvent notifies all registered stage event callbacks.
+func (p *Pipeline) notifyStageEvent(event StageEvent) {
+	for _, callback := range p.onStageEvent {
+		callback(event)
+	}
+}
+
+// count increments the counters of a stage for an event of the given kind.
+func (p *Pipeline) count(index int, kind StageEventKind) {
+	stats := &p.stats[index]
+	stats.calls.Add(1)
+	switch kind {
+	case StageRejected:
+		stats.rejections.Add(1)
+	case StageFailed:
+		stats.failures.Add(1)
+	case StagePropagated:
+		stats.propagated.Add(1)
+	case StageRecovered:
+		stats.recoveries.Add(1)
+	}
+}
+
+// Do executes op through the pipeline and returns its result. The zero value
+// is returned with any error. If a stage such as a Fallback recovered from a
+// failure, there is no result to return, so Do returns ErrNoResult; use a
+// FallbackChain around Do to fall back to a value instead.
+func Do[T any](ctx context.Context, p *Pipeline, op func(context.Context) (T, error)) (T, error) {
+	// An abandoned attempt may still finish after Do returned, so the result
+	// is only taken while the call is in progress
+	var mu sync.Mutex
+	var result T
+	produced, done := false, false
+
+	err := p.ExecuteContext(ctx, func(ctx context.Context) error {
+		v, err := op(ctx)
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil && !done {
+			result, produced = v, true
+		}
+		return err
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	done = true
+	var zero T
+	if err != nil {
+		return zero, err
+	}
+	if !produced {
+		return zero, ErrNoResult
+	}
+	return result, nil
+}
+
+// OnStageEvent registers a callback receiving how each stage handled each call.
+func (p *Pipeline) OnStageEvent(callback StageEventCallback) {
+	p.onStageEvent = append(p.onStageEvent, callback)
+}
+
+// Name returns the name of the pipeline.
+func (p *Pipeline) Name() string {
+	return p.name
+}
+
+// GetMetrics returns the current metrics of the pipeline.
+func (p *Pipeline) GetMetrics() PipelineMetrics {
+	metrics := PipelineMetrics{
+		Name:              p.name,
+		Calls:             p.calls.Load(),
+		Failures:          p.failures.Load(),
+		RejectedBy:        make(map[string]uint64),
+		FailedBy:          make(map[string]uint64),
+		OperationFailures: p.operationFailures.Load(),
+		Stages:            make([]StageMetrics, len(p.stages)),
+	}
+
+	for i, stage := range p.stages {
+		stats := &p.stats[i]
+		metrics.Stages[i] = StageMetrics{
+			Name:       stage.Name(),
+			Calls:      stats.calls.Load(),
+			Rejections: stats.rejections.Load(),
+			Failures:   stats.failures.Load(),
+			Propagated: stats.propagated.Load(),
+			Recoveries: stats.recoveries.Load(),
+		}
+		if n := stats.rejectedCalls.Load(); n > 0 {
+			metrics.RejectedBy[stage.Name()] += n
+		}
+		if n := stats.failedCalls.Load(); n > 0 {
+			metrics.FailedBy[stage.Name()] += n
+		}
+	}
+
+	return metrics
+}
+
+// Timeout is a Policy bounding the duration of each call. The operation's
+// context is canceled once the timeout expires, and a call still running by
+// then returns ErrCallTimeout.
+type Timeout struct {
+	name    string
+	timeout time.Duration
+	clock   clock.Clock
+}
+
+// NewTimeout creates a new Timeout policy. A timeout of zero or less means no
+// timeout, like Settings.CallTimeout. If clk is nil, the real clock is used.
+func NewTimeout(name string, timeout time.Duration, clk Clock) *Timeout {
+	if name == "" {
+		name = "timeout"
+	}
+	return &Timeout{
+		name:    name,
+		timeout: timeout,
+		clock:   clock.OrReal(clk),
+	}
+}
+
+// ExecuteContext executes the given function with a context canceled once the timeout expires.
+func (t *Timeout) ExecuteContext(ctx context.Context, op func(context.Context) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if t.timeout <= 0 {
+		return op(ctx)
+	}
+
+	callCtx, cancel := clock.WithTimeoutCause(ctx, t.clock, t.timeout, ErrCallTimeout)
+	defer cancel()
+
+	return callTimeoutError(ctx, callCtx, op(callCtx))
+}
+
+// Name returns the name of the timeout policy.
+func (t *Timeout) Name() string {
+	return t.name
+}
@@ -0,0 +1,240 @@
+package gomian
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nutcase/gomian/clocktest"
+)
+
+// recordingPolicy is a Policy that records the order in which stages run.
+type recordingPolicy struct {
+	name  string
+	order *[]string
+}
+
+func (p recordingPolicy) Name() string {
+	return p.name
+}
+
+func (p recordingPolicy) ExecuteContext(ctx context.Context, op func(context.Context) error) error {
+	*p.order = append(*p.order, p.name)
+	return op(ctx)
+}
+
+type contextKey struct{}
+
+func TestPipelineOrder(t *testing.T) {
+	var order []string
+	p := NewPipeline("TestPipeline",
+		recordingPolicy{"outer", &order},
+		recordingPolicy{"middle", &order},
+		recordingPolicy{"inner", &order},
+	)
+
+	var events []StageEvent
+	p.OnStageEvent(func(event StageEvent) {
+		events = append(events, event)
+	})
+
+	ctx := context.WithValue(context.Background(), contextKey{}, "value")
+	err := p.ExecuteContext(ctx, func(ctx context.Context) error {
+		order = append(order, "operation")
+		if ctx.Value(contextKey{}) != "value" {
+			t.Error("Context should be passed on to the operation")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExecuteContext should succeed, got %v", err)
+	}
+
+	want := []string{"outer", "middle", "inner", "operation"}
+	if len(order) != len(want) {
+		t.Fatalf("Stages should run in order %v, got %v", want, order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("Stages should run in order %v, got %v", want, order)
+		}
+	}
+
+	// Events are emitted as stages return, innermost first
+	if len(events) != 3 || events[0].Stage != "inner" || events[2].Stage != "outer" || events[2].Index != 0 {
+		t.Errorf("Unexpected events %+v", events)
+	}
+	for _, event := range events {
+		if event.Kind != StageSuccess || event.Pipeline != "TestPipeline" {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	}
+}
+
+func TestPipelineStageDuration(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	var order []string
+	p := NewPipelineWithClock("TestPipeline", clk, recordingPolicy{"outer", &order}, recordingPolicy{"inner", &order})
+
+	var durations []time.Duration
+	p.OnStageEvent(func(event StageEvent) {
+		durations = append(durations, event.Duration)
+	})
+
+	p.Execute(func() error {
+		clk.Advance(time.Second)
+		return nil
+	})
+	if len(durations) != 2 || durations[0] != time.Second || durations[1] != time.Second {
+		t.Errorf("Stage durations should be measured with the pipeline's clock, got %v", durations)
+	}
+}
+
+func TestPipelineRejectedBy(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{Name: "TestBreaker", FailureThreshold: ConsecutiveFailures(1), Timeout: time.Hour})
+	defer cb.Close()
+	retry := NewRetry(RetrySettings{Name: "TestRetry", MaxAttempts: 3})
+	p := NewPipeline("TestPipeline", retry, cb)
+
+	var kinds []string
+	p.OnStageEvent(func(event StageEvent) {
+		kinds = append(kinds, event.Stage+":"+event.Kind.String())
+	})
+
+	// The first attempt fails and trips the circuit, which rejects the retry
+	testErr := errors.New("test error")
+	err := p.Execute(func() error {
+		return testErr
+	})
+	if !IsCircuitOpen(err) {
+		t.Fatalf("Execute should return the circuit rejection, got %v", err)
+	}
+
+	want := []string{"TestBreaker:Propagated", "TestBreaker:Rejected", "TestRetry:Propagated"}
+	if len(kinds) != len(want) {
+		t.Fatalf("Events should be %v, got %v", want, kinds)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("Events should be %v, got %v", want, kinds)
+		}
+	}
+
+	metrics := p.GetMetrics()
+	if metrics.Calls != 1 || metrics.Failures != 1 || metrics.RejectedBy["TestBreaker"] != 1 || metrics.OperationFailures != 0 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+	if stage := metrics.Stages[1]; stage.Name != "TestBreaker" || stage.Calls != 2 || stage.Rejections != 1 || stage.Propagated != 1 {
+		t.Errorf("Unexpected breaker stage metrics %+v", stage)
+	}
+}
+
+func TestPipelineFallback(t *testing.T) {
+	testErr := errors.New("test error")
+	fallback := NewFallback("TestFallback", func(ctx context.Context, err error) error {
+		if err != testErr {
+			t.Errorf("Fallback should receive the error, got %v", err)
+		}
+		return nil
+	})
+	p := NewPipeline("TestPipeline", fallback)
+
+	if err := p.Execute(func() error { return testErr }); err != nil {
+		t.Errorf("Fallback should recover from the failure, got %v", err)
+	}
+
+	metrics := p.GetMetrics()
+	if metrics.Failures != 0 || metrics.Stages[0].Recoveries != 1 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+
+	// Failures not recovered from are counted against the operation
+	p = NewPipeline("TestPipeline", NewFallback("TestFallback", func(ctx context.Context, err error) error {
+		return err
+	}))
+	p.Execute(func() error { return testErr })
+	if metrics := p.GetMetrics(); metrics.OperationFailures != 1 || metrics.Stages[0].Propagated != 1 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+}
+
+func TestPipelineTimeout(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	p := NewPipeline("TestPipeline", NewTimeout("TestTimeout", time.Second, clk))
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.ExecuteContext(context.Background(), func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	waitFor(t, func() bool { return clk.PendingTimers() == 1 })
+	clk.Advance(time.Second)
+
+	err := <-errc
+	if !errors.Is(err, ErrCallTimeout) {
+		t.Fatalf("ExecuteContext should return ErrCallTimeout, got %v", err)
+	}
+	if metrics := p.GetMetrics(); metrics.FailedBy["TestTimeout"] != 1 || metrics.Stages[0].Failures != 1 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+
+	// Calls finishing in time are unaffected
+	if err := p.Execute(func() error { return nil }); err != nil {
+		t.Errorf("Execute should succeed, got %v", err)
+	}
+
+	// A timeout of zero means no timeout
+	p = NewPipeline("TestPipeline", NewTimeout("TestTimeout", 0, clk))
+	if err := p.Execute(func() error { return nil }); err != nil || clk.PendingTimers() != 0 {
+		t.Errorf("Execute should succeed without a timer, got %v with %d pending", err, clk.PendingTimers())
+	}
+}
+
+func TestDo(t *testing.T) {
+	p := NewPipeline("TestPipeline", NewTimeout("TestTimeout", time.Second, nil))
+
+	n, err := Do(context.Background(), p, func(ctx context.Context) (int, error) {
+		return strconv.Atoi("42")
+	})
+	if err != nil || n != 42 {
+		t.Errorf("Do should return the result, got %v, %v", n, err)
+	}
+
+	n, err = Do(context.Background(), p, func(ctx context.Context) (int, error) {
+		return 7, errors.New("test error")
+	})
+	if err == nil || n != 0 {
+		t.Errorf("Do should return the zero value with an error, got %v, %v", n, err)
+	}
+
+	// A fallback recovering from the failure leaves no result to return
+	p = NewPipeline("TestPipeline", NewFallback("TestFallback", func(ctx context.Context, err error) error {
+		return nil
+	}))
+	n, err = Do(context.Background(), p, func(ctx context.Context) (int, error) {
+		return 7, errors.New("test error")
+	})
+	if !errors.Is(err, ErrNoResult) || n != 0 {
+		t.Errorf("Do should return ErrNoResult after a fallback recovered, got %v, %v", n, err)
+	}
+}
+
+func TestStageEventKindString(t *testing.T) {
+	tests := map[StageEventKind]string{
+		StageSuccess:       "Success",
+		StageRejected:      "Rejected",
+		StageFailed:        "Failed",
+		StagePropagated:    "Propagated",
+		StageRecovered:     "Recovered",
+		StageEventKind(42): "Unknown StageEventKind(42)",
+	}
+	for kind, want := range tests {
+		if got := kind.String(); got != want {
+			t.Errorf("StageEventKind(%d).String() = %q, want %q", int(kind), got, want)
+		}
+	}
+}
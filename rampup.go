@@ -0,0 +1,70 @@
+package gomian
+
+import (
+	"math"
+	"time"
+)
+
+// A ramp-up admits minRampUpFraction of requests at its very start, so that
+// traffic resumes at once. Exponential ramp-up grows that fraction
+// continuously, so that it has doubled rampUpDoublings times by the end.
+const (
+	rampUpDoublings   = 6
+	minRampUpFraction = 1.0 / (1 << rampUpDoublings)
+)
+
+// rampUpFraction returns the fraction of requests admitted once elapsed of a
+// ramp-up lasting duration has passed.
+func rampUpFraction(strategy RampUpStrategy, elapsed, duration time.Duration) float64 {
+	if elapsed >= duration {
+		return 1
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	progress := float64(elapsed) / float64(duration)
+
+	var fraction float64
+	switch strategy {
+	case ExponentialRampUp:
+		fraction = math.Exp2(rampUpDoublings * (progress - 1))
+	default:
+		fraction = progress
+	}
+	return math.Max(fraction, minRampUpFraction)
+}
+
+// startRampUp begins a ramp-up phase if one is configured.
+func (cb *CircuitBreaker) startRampUp() {
+	if cb.settings.RampUp > 0 {
+		start := cb.clock.Now()
+		cb.rampUpStart.Store(&start)
+	}
+}
+
+// stopRampUp ends the current ramp-up phase, if any.
+func (cb *CircuitBreaker) stopRampUp() {
+	cb.rampUpStart.Store(nil)
+}
+
+// currentRampUpFraction returns the fraction of requests currently admitted.
+// It is 1 outside a ramp-up phase; the phase ends by itself once its duration
+// has elapsed.
+func (cb *CircuitBreaker) currentRampUpFraction() float64 {
+	start := cb.rampUpStart.Load()
+	if start == nil {
+		return 1
+	}
+
+	fraction := rampUpFraction(cb.settings.RampUpStrategy, cb.clock.Since(*start), cb.settings.RampUp)
+	if fraction >= 1 {
+		cb.rampUpStart.CompareAndSwap(start, nil)
+	}
+	return fraction
+}
+
+// admitDuringRampUp reports whether a request may pass during the ramp-up phase.
+func (cb *CircuitBreaker) admitDuringRampUp() bool {
+	fraction := cb.currentRampUpFraction()
+	return fraction >= 1 || cb.rand() < fraction
+}
@@ -0,0 +1,156 @@
+package gomian
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nutcase/gomian/clocktest"
+)
+
+func TestRampUpFraction(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy RampUpStrategy
+		elapsed  time.Duration
+		want     float64
+	}{
+		{"linear start", LinearRampUp, 0, minRampUpFraction},
+		{"linear half", LinearRampUp, 5 * time.Second, 0.5},
+		{"linear end", LinearRampUp, 10 * time.Second, 1},
+		{"linear after end", LinearRampUp, time.Minute, 1},
+		{"exponential start", ExponentialRampUp, 0, minRampUpFraction},
+		{"exponential half", ExponentialRampUp, 5 * time.Second, 1.0 / 8},
+		{"exponential end", ExponentialRampUp, 10 * time.Second, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rampUpFraction(tt.strategy, tt.elapsed, 10*time.Second)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Fraction should be %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRampUpStrategyString(t *testing.T) {
+	if LinearRampUp.String() != "Linear" || ExponentialRampUp.String() != "Exponential" {
+		t.Errorf("Unexpected strategy names: %s, %s", LinearRampUp, ExponentialRampUp)
+	}
+	if RampUpStrategy(99).String() != "Unknown RampUpStrategy(99)" {
+		t.Errorf("Unexpected unknown strategy name: %s", RampUpStrategy(99))
+	}
+
+	settings := DefaultSettings()
+	settings.RampUpStrategy = RampUpStrategy(99)
+	if err := settings.Validate(); !errors.Is(err, ErrInvalidSettings) {
+		t.Errorf("An unknown strategy should be invalid, got %v", err)
+	}
+}
+
+func TestCircuitBreakerRampUp(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb, err := New("TestBreaker",
+		WithConsecutiveFailures(1),
+		WithTimeout(time.Second),
+		WithRampUp(10*time.Second, LinearRampUp),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+	cb.rand = func() float64 { return 0.5 }
+
+	rejections := 0
+	cb.OnRejection(func(name string) { rejections++ })
+
+	// No ramp-up before the circuit has ever tripped
+	if err := cb.Execute(func() error { return nil }); err != nil {
+		t.Fatalf("Request should succeed, got %v", err)
+	}
+	if cb.GetMetrics().RampingUp {
+		t.Error("Circuit should not be ramping up before it has tripped")
+	}
+
+	// Trip, wait for Half-Open and close again with a successful probe
+	cb.Execute(func() error { return errors.New("failure") })
+	clk.Advance(time.Second)
+	if err := cb.Execute(func() error { return nil }); err != nil {
+		t.Fatalf("Probe should succeed, got %v", err)
+	}
+	if cb.State() != Closed {
+		t.Fatalf("Circuit should be closed, got %v", cb.State())
+	}
+
+	metrics := cb.GetMetrics()
+	if !metrics.RampingUp || metrics.RampUpFraction != minRampUpFraction {
+		t.Errorf("Circuit should start ramping up, got RampingUp=%v RampUpFraction=%v", metrics.RampingUp, metrics.RampUpFraction)
+	}
+
+	err = cb.Execute(func() error {
+		t.Error("Request should not be executed during early ramp-up")
+		return nil
+	})
+	var circuitErr *CircuitError
+	if !errors.Is(err, ErrRampUpRejected) || !errors.As(err, &circuitErr) || circuitErr.Reason != ReasonRampUp {
+		t.Errorf("Should return ErrRampUpRejected, got %v", err)
+	}
+	if rejections != 1 {
+		t.Errorf("Rejection callback should be called once, got %d", rejections)
+	}
+
+	// Past the middle of the ramp-up more than half of requests are admitted
+	clk.Advance(6 * time.Second)
+	if err := cb.Execute(func() error { return nil }); err != nil {
+		t.Errorf("Request should be admitted, got %v", err)
+	}
+
+	// Once the ramp-up is over the circuit is fully closed
+	clk.Advance(4 * time.Second)
+	metrics = cb.GetMetrics()
+	if metrics.RampingUp || metrics.RampUpFraction != 1 {
+		t.Errorf("Ramp-up should be over, got RampingUp=%v RampUpFraction=%v", metrics.RampingUp, metrics.RampUpFraction)
+	}
+
+	// Tripping during a ramp-up ends it
+	cb.Execute(func() error { return errors.New("failure") })
+	clk.Advance(time.Second)
+	cb.Execute(func() error { return nil })
+	if !cb.GetMetrics().RampingUp {
+		t.Fatal("Circuit should be ramping up again")
+	}
+	cb.rand = func() float64 { return 0 }
+	cb.Execute(func() error { return errors.New("failure") })
+	if cb.State() != Open {
+		t.Fatalf("Circuit should be open, got %v", cb.State())
+	}
+	if cb.rampUpStart.Load() != nil || cb.GetMetrics().RampingUp {
+		t.Error("Tripping should end the ramp-up")
+	}
+}
+
+func TestCircuitBreakerRampUpAtUnixEpoch(t *testing.T) {
+	// The circuit closes, and the ramp-up starts, at exactly Unix time 0
+	clk := clocktest.NewFakeClock(time.Unix(-1, 0))
+	cb, err := New("TestBreaker",
+		WithConsecutiveFailures(1),
+		WithTimeout(time.Second),
+		WithRampUp(10*time.Second, LinearRampUp),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	cb.Execute(func() error { return errors.New("failure") })
+	clk.Advance(time.Second)
+	cb.Execute(func() error { return nil })
+
+	if cb.State() != Closed || !cb.GetMetrics().RampingUp {
+		t.Errorf("Circuit should be ramping up after closing at Unix time 0, got %+v", cb.GetMetrics())
+	}
+}
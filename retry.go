@@ -0,0 +1,318 @@
+package gomian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nutcase/gomian/internal/clock"
+	"github.com/nutcase/gomian/internal/counter"
+)
+
+// RetrySettings defines the configuration for a Retry policy.
+type RetrySettings struct {
+	// Name is a unique identifier for this retry policy.
+	Name string
+
+	// MaxAttempts is the maximum number of attempts per call, including the first one.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry. Each following
+	// retry waits Multiplier times longer, up to MaxBackoff. Zero retries
+	// immediately, and a negative value is replaced by the default.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Jitter is the fraction of each delay, between 0 and 1, that is
+	// randomized: a delay d is shortened to a random value between
+	// d*(1-Jitter) and d, so that callers failing together do not retry in lockstep.
+	Jitter float64
+
+	// IsRetryable reports whether a failed attempt should be retried. If nil,
+	// every error is retried except rejections by a throttle or circuit breaker.
+	// Circuit breaker rejections are never passed to it.
+	IsRetryable func(error) bool
+
+	// WaitRetryAfter makes a call rejected by an Open circuit breaker wait for
+	// the CircuitError's RetryAfter and try again, provided it is no longer than
+	// MaxBackoff. Otherwise, and for other rejections, retrying stops right away.
+	WaitRetryAfter bool
+
+	// BudgetRatio, if positive, caps the number of retries within BudgetWindow
+	// to this fraction of the calls made in it, plus BudgetMinRetries. Retries
+	// beyond the budget are not made, so that retries cannot multiply the load
+	// on a struggling dependency.
+	BudgetRatio      float64
+	BudgetMinRetries uint64
+	BudgetWindow     time.Duration
+
+	// Clock is the source of time for the delays and the budget window.
+	// If nil, the real clock is used.
+	Clock Clock
+
+	// Rand returns a pseudo-random number in [0, 1) used for the jitter.
+	// If nil, math/rand/v2's Float64 is used.
+	Rand func() float64
+}
+
+// RetryCallback is a function that is called before a failed call is retried.
+// attempt is the number of the attempt that failed, starting at 1, and delay
+// how long the retry waits before the next attempt.
+type RetryCallback func(name string, attempt int, err error, delay time.Duration)
+
+// RetryMetrics represents the current metrics of a Retry policy.
+type RetryMetrics struct {
+	Name string
+
+	// Calls counts calls, however many attempts they took, and Failures
+	// those that failed after their last attempt.
+	Calls    uint64
+	Failures uint64
+
+	// Retries is the number of retries made, and RetriesDenied the number not
+	// made because the retry budget was exhausted.
+	Retries       uint64
+	RetriesDenied uint64
+}
+
+// DefaultRetrySettings returns a RetrySettings struct with sensible default values.
+func DefaultRetrySettings() RetrySettings {
+	return RetrySettings{
+		Name:           "default",
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		BudgetWindow:   10 * time.Second,
+	}
+}
+
+// Validate checks the settings for inconsistent or out-of-range values.
+// All problems are reported together, each wrapping ErrInvalidSettings.
+func (s RetrySettings) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrInvalidSettings, fmt.Sprintf(format, args...)))
+	}
+
+	if s.Name == "" {
+		invalid("name must not be empty")
+	}
+	if s.MaxAttempts < 1 {
+		invalid("max attempts must be at least 1, got %d", s.MaxAttempts)
+	}
+	if s.InitialBackoff < 0 {
+		invalid("initial backoff must not be negative, got %v", s.InitialBackoff)
+	}
+	if s.MaxBackoff < s.InitialBackoff {
+		invalid("max backoff %v must not be less than initial backoff %v", s.MaxBackoff, s.InitialBackoff)
+	}
+	if s.Multiplier < 1 {
+		invalid("backoff multiplier must be at least 1, got %v", s.Multiplier)
+	}
+	if s.Jitter < 0 || s.Jitter > 1 {
+		invalid("jitter must be between 0 and 1, got %v", s.Jitter)
+	}
+	if s.BudgetRatio < 0 {
+		invalid("retry budget ratio must not be negative, got %v", s.BudgetRatio)
+	}
+	if s.BudgetRatio > 0 && s.BudgetWindow <= 0 {
+		invalid("retry budget window must be positive, got %v", s.BudgetWindow)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Retry retries failed calls with exponential backoff and jitter. It
+// cooperates with circuit breakers: a call rejected by a breaker is not
+// retried, or only once the circuit lets probes through again with
+// WaitRetryAfter, so that retries do not keep hammering an Open circuit.
+//
+// Wrap the breaker's ExecuteContext in a Retry to retry calls through it:
+//
+//	err := retry.ExecuteContext(ctx, func(ctx context.Context) error {
+//		return cb.ExecuteContext(ctx, op)
+//	})
+type Retry struct {
+	name     string
+	settings RetrySettings
+	clock    clock.Clock
+	rand     func() float64
+	budget   counter.Window // calls as successes and retries as failures
+	budgetMu sync.Mutex     // makes checking and recording a retry atomic
+	requests atomic.Uint64
+	failures atomic.Uint64
+	retries  atomic.Uint64
+	denied   atomic.Uint64
+	onRetry  []RetryCallback
+}
+
+// NewRetry creates a new Retry policy with the provided settings.
+func NewRetry(settings RetrySettings) *Retry {
+	defaults := DefaultRetrySettings()
+	if settings.Name == "" {
+		settings.Name = defaults.Name
+	}
+	if settings.MaxAttempts <= 0 {
+		settings.MaxAttempts = defaults.MaxAttempts
+	}
+	if settings.InitialBackoff < 0 {
+		settings.InitialBackoff = defaults.InitialBackoff
+	}
+	if settings.MaxBackoff <= 0 {
+		settings.MaxBackoff = max(defaults.MaxBackoff, settings.InitialBackoff)
+	}
+	if settings.Multiplier <= 0 {
+		settings.Multiplier = defaults.Multiplier
+	}
+	if settings.BudgetWindow <= 0 {
+		settings.BudgetWindow = defaults.BudgetWindow
+	}
+
+	r := &Retry{
+		name:     settings.Name,
+		settings: settings,
+		clock:    clock.OrReal(settings.Clock),
+		rand:     settings.Rand,
+	}
+	if r.rand == nil {
+		r.rand = rand.Float64
+	}
+
+	if settings.BudgetRatio > 0 {
+		r.budget = counter.NewRollingWindowWithClock(settings.BudgetWindow, 10, r.clock)
+	}
+
+	return r
+}
+
+// Execute executes the given function, retrying it while it fails.
+func (r *Retry) Execute(op func() error) error {
+	return r.ExecuteContext(context.Background(), func(ctx context.Context) error {
+		return op()
+	})
+}
+
+// ExecuteContext executes the given function with context, retrying it while
+// it fails. It returns the error of the last attempt. If ctx is done while
+// waiting for a retry, the returned error wraps both ctx's error and that of
+// the last attempt.
+func (r *Retry) ExecuteContext(ctx context.Context, op func(context.Context) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	r.requests.Add(1)
+	if r.budget != nil {
+		r.budget.IncrementSuccess()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil {
+			return nil
+		}
+
+		delay, retry := r.retryDelay(attempt, err)
+		if !retry || ctx.Err() != nil {
+			r.failures.Add(1)
+			return err
+		}
+		if !r.withinBudget() {
+			r.denied.Add(1)
+			r.failures.Add(1)
+			return err
+		}
+
+		r.retries.Add(1)
+		for _, callback := range r.onRetry {
+			callback(r.name, attempt, err, delay)
+		}
+		if sleepErr := clock.Sleep(ctx, r.clock, delay); sleepErr != nil {
+			r.failures.Add(1)
+			return fmt.Errorf("%w (last error: %w)", sleepErr, err)
+		}
+	}
+}
+
+// retryDelay decides whether a call whose attempt failed with err is retried,
+// and how long to wait before the next attempt.
+func (r *Retry) retryDelay(attempt int, err error) (time.Duration, bool) {
+	if attempt >= r.settings.MaxAttempts {
+		return 0, false
+	}
+
+	var circuitErr *CircuitError
+	if errors.As(err, &circuitErr) {
+		if !r.settings.WaitRetryAfter || circuitErr.Reason != ReasonOpen ||
+			circuitErr.RetryAfter <= 0 || circuitErr.RetryAfter > r.settings.MaxBackoff {
+			return 0, false
+		}
+		return circuitErr.RetryAfter, true
+	}
+
+	if r.settings.IsRetryable != nil {
+		if !r.settings.IsRetryable(err) {
+			return 0, false
+		}
+	} else if IsCircuitOpen(err) || errors.Is(err, ErrThrottled) {
+		return 0, false
+	}
+
+	return r.backoff(attempt), true
+}
+
+// backoff returns the jittered delay after the given failed attempt.
+func (r *Retry) backoff(attempt int) time.Duration {
+	d := float64(r.settings.InitialBackoff) * math.Pow(r.settings.Multiplier, float64(attempt-1))
+	d = min(d, float64(r.settings.MaxBackoff))
+	return time.Duration(d * (1 - r.settings.Jitter*r.rand()))
+}
+
+// withinBudget reports whether a retry is allowed by the retry budget, and
+// counts it if so.
+func (r *Retry) withinBudget() bool {
+	if r.budget == nil {
+		return true
+	}
+
+	// Concurrent retries must not all pass the check before any is recorded
+	r.budgetMu.Lock()
+	defer r.budgetMu.Unlock()
+
+	total, retries := r.budget.Counts()
+	calls := total - retries
+	if float64(retries+1) > r.settings.BudgetRatio*float64(calls)+float64(r.settings.BudgetMinRetries) {
+		return false
+	}
+	r.budget.IncrementFailure()
+	return true
+}
+
+// OnRetry registers a callback for calls about to be retried.
+func (r *Retry) OnRetry(callback RetryCallback) {
+	r.onRetry = append(r.onRetry, callback)
+}
+
+// Name returns the name of the retry policy.
+func (r *Retry) Name() string {
+	return r.name
+}
+
+// GetMetrics returns the current metrics of the retry policy.
+func (r *Retry) GetMetrics() RetryMetrics {
+	return RetryMetrics{
+		Name:          r.name,
+		Calls:         r.requests.Load(),
+		Failures:      r.failures.Load(),
+		Retries:       r.retries.Load(),
+		RetriesDenied: r.denied.Load(),
+	}
+}
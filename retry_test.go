@@ -0,0 +1,322 @@
+package gomian
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nutcase/gomian/clocktest"
+)
+
+// advanceWhilePending runs f, advancing clk by step whenever a timer is
+// pending, until f returns.
+func advanceWhilePending(clk *clocktest.FakeClock, step time.Duration, f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if clk.PendingTimers() > 0 {
+			clk.Advance(step)
+		}
+		time.Sleep(100 * time.Microsecond)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	r := NewRetry(RetrySettings{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     3,
+		Jitter:         0.2,
+		Rand:           func() float64 { return 0.5 },
+	})
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 90 * time.Millisecond},
+		{2, 270 * time.Millisecond},
+		{3, 810 * time.Millisecond},
+		{4, 900 * time.Millisecond}, // capped at MaxBackoff before jitter
+	}
+	for _, tt := range tests {
+		if got := r.backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestRetryExecute(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	r := NewRetry(RetrySettings{
+		Name:           "TestRetry",
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		Multiplier:     2,
+		Clock:          clk,
+	})
+
+	var delays []time.Duration
+	r.OnRetry(func(name string, attempt int, err error, delay time.Duration) {
+		delays = append(delays, delay)
+	})
+
+	testErr := errors.New("test error")
+	attempts := 0
+	var err error
+	advanceWhilePending(clk, 100*time.Millisecond, func() {
+		err = r.Execute(func() error {
+			attempts++
+			if attempts < 3 {
+				return testErr
+			}
+			return nil
+		})
+	})
+
+	if err != nil {
+		t.Errorf("Execute should succeed on the last attempt, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Operation should be attempted 3 times, got %d", attempts)
+	}
+	if len(delays) != 2 || delays[0] != 100*time.Millisecond || delays[1] != 200*time.Millisecond {
+		t.Errorf("Retries should back off exponentially, got %v", delays)
+	}
+
+	// Every attempt fails, so the last error is returned
+	attempts = 0
+	advanceWhilePending(clk, 100*time.Millisecond, func() {
+		err = r.Execute(func() error {
+			attempts++
+			return testErr
+		})
+	})
+	if err != testErr || attempts != 3 {
+		t.Errorf("Execute should return the last error after 3 attempts, got %v after %d", err, attempts)
+	}
+
+	metrics := r.GetMetrics()
+	if metrics.Calls != 2 || metrics.Failures != 1 || metrics.Retries != 4 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+}
+
+func TestRetryImmediate(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	r := NewRetry(RetrySettings{MaxAttempts: 3, InitialBackoff: 0, Clock: clk})
+
+	// Without a backoff, retries are made right away without waiting on the clock
+	attempts := 0
+	err := r.Execute(func() error {
+		attempts++
+		return errors.New("test error")
+	})
+	if err == nil || attempts != 3 {
+		t.Errorf("Execute should make 3 attempts without waiting, got %v after %d", err, attempts)
+	}
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	permanentErr := errors.New("permanent")
+	r := NewRetry(RetrySettings{
+		IsRetryable: func(err error) bool {
+			return err != permanentErr
+		},
+	})
+
+	attempts := 0
+	err := r.Execute(func() error {
+		attempts++
+		return permanentErr
+	})
+	if err != permanentErr || attempts != 1 {
+		t.Errorf("Non-retryable error should be returned right away, got %v after %d attempts", err, attempts)
+	}
+
+	// Throttled requests are not retried by default
+	attempts = 0
+	err = NewRetry(RetrySettings{}).Execute(func() error {
+		attempts++
+		return ErrThrottled
+	})
+	if err != ErrThrottled || attempts != 1 {
+		t.Errorf("Throttled request should not be retried, got %v after %d attempts", err, attempts)
+	}
+}
+
+func TestRetryCircuitOpen(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Unix(0, 0))
+	newBreaker := func() *CircuitBreaker {
+		cb, err := New("TestBreaker",
+			WithConsecutiveFailures(1),
+			WithTimeout(time.Second),
+			WithClock(clk),
+			WithLazyTransitions(),
+		)
+		if err != nil {
+			t.Fatalf("New should succeed, got %v", err)
+		}
+		return cb
+	}
+	testErr := errors.New("test error")
+
+	// Retrying stops as soon as the circuit rejects the call
+	cb := newBreaker()
+	r := NewRetry(RetrySettings{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, Clock: clk})
+	attempts := 0
+	var err error
+	advanceWhilePending(clk, 100*time.Millisecond, func() {
+		err = r.Execute(func() error {
+			return cb.Execute(func() error {
+				attempts++
+				return testErr
+			})
+		})
+	})
+	if !IsCircuitOpen(err) || attempts != 1 {
+		t.Errorf("Retry should stop on an open circuit, got %v after %d attempts", err, attempts)
+	}
+
+	// With WaitRetryAfter, the retry waits for the circuit to let a probe through
+	cb = newBreaker()
+	r = NewRetry(RetrySettings{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		WaitRetryAfter: true,
+		Clock:          clk,
+	})
+	var delays []time.Duration
+	r.OnRetry(func(name string, attempt int, err error, delay time.Duration) {
+		delays = append(delays, delay)
+	})
+	attempts = 0
+	advanceWhilePending(clk, 100*time.Millisecond, func() {
+		err = r.Execute(func() error {
+			return cb.Execute(func() error {
+				attempts++
+				if attempts == 1 {
+					return testErr
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("Retry should succeed once the circuit is half-open, got %v after %d attempts", err, attempts)
+	}
+	if len(delays) != 2 || delays[1] != 900*time.Millisecond {
+		t.Errorf("Retry should wait for the circuit's RetryAfter, got %v", delays)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	r := NewRetry(RetrySettings{
+		MaxAttempts:    2,
+		InitialBackoff: time.Nanosecond,
+		BudgetRatio:    0.5,
+	})
+
+	testErr := errors.New("test error")
+	attempts := 0
+	for range 4 {
+		r.Execute(func() error {
+			attempts++
+			return testErr
+		})
+	}
+
+	// Calls 2 and 4 are each allowed one retry by the 50% budget
+	if attempts != 6 {
+		t.Errorf("Operation should be attempted 6 times, got %d", attempts)
+	}
+	metrics := r.GetMetrics()
+	if metrics.Retries != 2 || metrics.RetriesDenied != 2 {
+		t.Errorf("Budget should allow 2 retries and deny 2, got %+v", metrics)
+	}
+}
+
+func TestRetryBudgetConcurrent(t *testing.T) {
+	const calls = 50
+	r := NewRetry(RetrySettings{
+		MaxAttempts: 2,
+		BudgetRatio: 0.1,
+	})
+
+	// Every call fails its first attempt at the same time, once all calls
+	// have been counted in the budget
+	var entered, wg sync.WaitGroup
+	entered.Add(calls)
+	wg.Add(calls)
+	for range calls {
+		go func() {
+			defer wg.Done()
+			attempt := 0
+			r.Execute(func() error {
+				attempt++
+				if attempt == 1 {
+					entered.Done()
+					entered.Wait()
+					return errors.New("test error")
+				}
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	if metrics := r.GetMetrics(); metrics.Retries != 5 || metrics.RetriesDenied != calls-5 {
+		t.Errorf("Budget should allow 5 retries for %d calls, got %+v", calls, metrics)
+	}
+}
+
+func TestRetryContextCanceled(t *testing.T) {
+	r := NewRetry(RetrySettings{InitialBackoff: time.Hour, MaxBackoff: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.OnRetry(func(name string, attempt int, err error, delay time.Duration) {
+		cancel()
+	})
+
+	testErr := errors.New("test error")
+	err := r.ExecuteContext(ctx, func(ctx context.Context) error {
+		return testErr
+	})
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, testErr) {
+		t.Errorf("Error should wrap both the context error and the last error, got %v", err)
+	}
+}
+
+func TestRetrySettingsValidate(t *testing.T) {
+	if err := DefaultRetrySettings().Validate(); err != nil {
+		t.Errorf("Default settings should be valid, got %v", err)
+	}
+
+	err := RetrySettings{
+		Name:           "TestRetry",
+		MaxAttempts:    0,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Millisecond,
+		Multiplier:     0.5,
+		Jitter:         2,
+		BudgetRatio:    0.1,
+	}.Validate()
+	if !errors.Is(err, ErrInvalidSettings) {
+		t.Fatalf("Validate should return ErrInvalidSettings, got %v", err)
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 5 {
+		t.Errorf("Validate should report 5 problems, got %d: %v", n, err)
+	}
+}
@@ -1,6 +1,8 @@
 package gomian
 
 import (
+	"errors"
+	"fmt"
 	"time"
 )
 
@@ -56,6 +58,81 @@ func NewFailureRateThreshold(rate float64, samples uint64) FailureThresholdType
 	return FailureRateThreshold{Rate: rate, Samples: samples}
 }
 
+// WindowType selects how the window used for failure rate calculation is measured.
+type WindowType int
+
+const (
+	// TimeWindow counts the requests made within the last RollingWindow duration.
+	TimeWindow WindowType = iota
+
+	// CountWindow counts the last WindowCount requests, however long they took.
+	// It suits low-traffic dependencies where a time window rarely fills up.
+	CountWindow
+)
+
+// String returns a string representation of the WindowType.
+func (w WindowType) String() string {
+	switch w {
+	case TimeWindow:
+		return "TimeWindow"
+	case CountWindow:
+		return "CountWindow"
+	default:
+		return fmt.Sprintf("Unknown WindowType(%d)", w)
+	}
+}
+
+// RampUpStrategy selects how the fraction of admitted requests grows during
+// the ramp-up phase after a circuit closes.
+type RampUpStrategy int
+
+const (
+	// LinearRampUp admits a fraction of requests growing linearly with the
+	// time elapsed since the circuit closed.
+	LinearRampUp RampUpStrategy = iota
+
+	// ExponentialRampUp starts with a small fraction of requests that grows
+	// exponentially and continuously, doubling over every sixth of the
+	// ramp-up, so that most traffic returns towards its end.
+	ExponentialRampUp
+)
+
+// String returns a string representation of the RampUpStrategy.
+func (r RampUpStrategy) String() string {
+	switch r {
+	case LinearRampUp:
+		return "Linear"
+	case ExponentialRampUp:
+		return "Exponential"
+	default:
+		return fmt.Sprintf("Unknown RampUpStrategy(%d)", r)
+	}
+}
+
+// PanicPolicy selects what ExecuteContext does once it has recorded a panic
+// in the operation as a failure.
+type PanicPolicy int
+
+const (
+	// PanicPropagate panics again with the original value.
+	PanicPropagate PanicPolicy = iota
+
+	// PanicReturnError returns the panic as a *PanicError carrying the stack trace.
+	PanicReturnError
+)
+
+// String returns a string representation of the PanicPolicy.
+func (p PanicPolicy) String() string {
+	switch p {
+	case PanicPropagate:
+		return "Propagate"
+	case PanicReturnError:
+		return "ReturnError"
+	default:
+		return fmt.Sprintf("Unknown PanicPolicy(%d)", p)
+	}
+}
+
 // Settings defines the configuration for a CircuitBreaker.
 type Settings struct {
 	// Name is a unique identifier for this circuit breaker.
@@ -67,12 +144,25 @@ type Settings struct {
 	// SuccessThreshold is the number of consecutive successes required to close from Half-Open.
 	SuccessThreshold uint64
 
+	// HalfOpenMaxRequests is the number of probe requests admitted concurrently
+	// in the Half-Open state; the rest are rejected. If zero, probes are
+	// serialized instead, so that each waits for the previous one to finish.
+	HalfOpenMaxRequests uint64
+
 	// Timeout is the duration the circuit stays Open before transitioning to Half-Open.
 	Timeout time.Duration
 
 	// RollingWindow is the time window for failure rate calculation.
 	RollingWindow time.Duration
 
+	// WindowType selects between a time-based window (RollingWindow) and a
+	// count-based window (WindowCount) for failure rate calculation.
+	WindowType WindowType
+
+	// WindowCount is the number of most recent requests considered when
+	// WindowType is CountWindow.
+	WindowCount uint64
+
 	// MinimumRequestVolume is the minimum number of requests required within the RollingWindow
 	// before the failure rate calculation is applied.
 	MinimumRequestVolume uint64
@@ -81,12 +171,136 @@ type Settings struct {
 	// if no failures occur during that period.
 	ResetTimeout time.Duration
 
+	// Bulkhead, if set, limits the number of concurrent calls. Calls it
+	// rejects return ErrBulkheadFull and are ignored, unless BulkheadFailures
+	// is set, in which case they count as failures.
+	Bulkhead         *Bulkhead
+	BulkheadFailures bool
+
+	// Classify determines the Outcome of a call from its error, which is nil
+	// for a successful call. If nil, Outcomes, IsFailure and IgnoredErrors are
+	// consulted in that order. Context errors are handled by
+	// CallerContextErrors and DependencyContextErrors first.
+	Classify func(error) Outcome
+
+	// CallTimeout bounds the duration of each call. ExecuteContext passes the
+	// operation a context that is done once it expires, and a call still
+	// running by then returns ErrCallTimeout and counts as a failure. If zero,
+	// calls are only bounded by the caller's context.
+	CallTimeout time.Duration
+
+	// AbandonOnTimeout makes ExecuteContext return as soon as the call's
+	// context is done, whether from CallTimeout or the caller, instead of
+	// waiting for the operation to return. The operation keeps running in the
+	// background; Metrics.AbandonedCalls counts those still running.
+	AbandonOnTimeout bool
+
+	// PanicPolicy selects whether a panic in the operation, which is always
+	// recorded as a failure, is propagated or returned as a *PanicError.
+	// Panics in calls abandoned with AbandonOnTimeout are never propagated.
+	PanicPolicy PanicPolicy
+
+	// CallerContextErrors selects how context.Canceled and
+	// context.DeadlineExceeded are counted when the context passed to
+	// ExecuteContext is done, i.e. the caller gave up. By default they are ignored.
+	CallerContextErrors ContextErrorPolicy
+
+	// DependencyContextErrors selects how context.Canceled and
+	// context.DeadlineExceeded are counted when the caller's context is still
+	// live. By default they are classified like any other error.
+	DependencyContextErrors ContextErrorPolicy
+
+	// Outcomes assigns outcomes to the errors matched by each entry, the first
+	// match winning.
+	Outcomes []ErrorOutcome
+
 	// IsFailure is a custom function to determine if an error counts as a failure.
+	// Errors for which it returns false count as successes.
 	// If nil, any non-nil error is considered a failure.
 	IsFailure func(error) bool
 
-	// IgnoredErrors is a list of errors that should not count as failures.
+	// IgnoredErrors is a list of errors that are not counted at all, matched
+	// with errors.Is so that wrapped errors are recognized.
 	IgnoredErrors []error
+
+	// FailureWeight returns how much a failure counts towards the failure
+	// threshold, e.g. 2 for a timeout and 0.5 for an overload response. A
+	// weight of zero or less records the call as a success. If nil,
+	// FailureWeights is consulted.
+	FailureWeight func(error) float64
+
+	// FailureWeights assigns weights to the failures matched by each entry,
+	// the first match winning. Failures that match no entry weigh 1.
+	FailureWeights []ErrorWeight
+
+	// Logger receives diagnostic messages such as state changes.
+	// If nil, nothing is logged.
+	Logger Logger
+
+	// Clock is the source of time for the circuit breaker and its counters.
+	// If nil, the real clock is used.
+	Clock Clock
+
+	// LatencyWindow enables recording of call durations into a rolling histogram
+	// covering this duration, from which latency percentiles are reported in
+	// Metrics. If zero, latencies are not recorded.
+	LatencyWindow time.Duration
+
+	// LatencyWindowBuckets is the number of time slots the LatencyWindow is split
+	// into; a slot is the resolution at which old samples expire. If zero, 10 slots are used.
+	LatencyWindowBuckets int
+
+	// FailureScoreHalfLife is the time after which an outcome counts half as much
+	// towards the failure score. The score is tracked, and reported in Metrics,
+	// if this is set or a FailureScoreThreshold is used; in the latter case it
+	// defaults to RollingWindow.
+	FailureScoreHalfLife time.Duration
+
+	// LazyTransitions disables the background timers used for the Open to Half-Open
+	// transition and for ResetTimeout. Instead, their deadlines are stored and evaluated
+	// on the next call to ExecuteContext, State or GetMetrics. This avoids a runtime
+	// timer per breaker, which matters when many breakers are created.
+	LazyTransitions bool
+
+	// RampUp enables a ramp-up phase of this duration after the circuit closes
+	// from Half-Open. During it only a growing fraction of requests is admitted,
+	// so that a dependency that has just recovered is not hit by full traffic at
+	// once; the rest are rejected with ErrRampUpRejected. If zero, the circuit
+	// admits all requests as soon as it closes.
+	RampUp time.Duration
+
+	// RampUpStrategy selects how the admitted fraction grows during RampUp.
+	RampUpStrategy RampUpStrategy
+}
+
+// ErrorWeight assigns a weight to the failures matched by Match.
+type ErrorWeight struct {
+	// Match reports whether an error belongs to this weight class.
+	// ErrorIs and ErrorAs build common matchers.
+	Match func(error) bool
+	// Weight is how much a matched failure counts towards the failure threshold.
+	Weight float64
+}
+
+// ErrorIs returns a matcher for errors that wrap target, as reported by errors.Is.
+func ErrorIs(target error) func(error) bool {
+	return func(err error) bool {
+		return errors.Is(err, target)
+	}
+}
+
+// ErrorAs returns a matcher for errors that wrap an error of type T, as reported by errors.As.
+func ErrorAs[T error]() func(error) bool {
+	return func(err error) bool {
+		var target T
+		return errors.As(err, &target)
+	}
+}
+
+// Logger is the minimal logging interface used by the circuit breaker.
+// *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...any)
 }
 
 // DefaultSettings returns a Settings struct with sensible default values.
@@ -103,3 +317,133 @@ func DefaultSettings() Settings {
 		IgnoredErrors:       nil,
 	}
 }
+
+// Validate checks the settings for inconsistent or out-of-range values.
+// All problems are reported together, each wrapping ErrInvalidSettings.
+func (s Settings) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrInvalidSettings, fmt.Sprintf(format, args...)))
+	}
+
+	if s.Name == "" {
+		invalid("name must not be empty")
+	}
+
+	if s.FailureThreshold == nil {
+		invalid("failure threshold must be set")
+	} else {
+		s.validateThreshold(s.FailureThreshold, invalid)
+	}
+
+	if s.SuccessThreshold == 0 {
+		invalid("success threshold must be at least 1")
+	}
+	if s.Timeout <= 0 {
+		invalid("timeout must be positive, got %v", s.Timeout)
+	}
+	if s.LatencyWindow < 0 {
+		invalid("latency window must not be negative, got %v", s.LatencyWindow)
+	}
+	if s.LatencyWindowBuckets < 0 {
+		invalid("latency window buckets must not be negative, got %d", s.LatencyWindowBuckets)
+	}
+	if s.ResetTimeout < 0 {
+		invalid("reset timeout must not be negative, got %v", s.ResetTimeout)
+	}
+	for _, policy := range []ContextErrorPolicy{s.CallerContextErrors, s.DependencyContextErrors} {
+		if policy < ContextErrorDefault || policy > ContextErrorSlow {
+			invalid("unknown context error policy %v", policy)
+		}
+	}
+	for i, o := range s.Outcomes {
+		if o.Match == nil {
+			invalid("outcome %d must have a matcher", i)
+		}
+		if o.Outcome.Name == "" {
+			invalid("outcome %d must have a name", i)
+		}
+		if o.Outcome.Kind < KindSuccess || o.Outcome.Kind > KindSlow {
+			invalid("outcome %d has unknown kind %v", i, o.Outcome.Kind)
+		}
+		if o.Outcome.Weight < 0 {
+			invalid("outcome %d weight must not be negative, got %v", i, o.Outcome.Weight)
+		}
+	}
+	for i, w := range s.FailureWeights {
+		if w.Match == nil {
+			invalid("failure weight %d must have a matcher", i)
+		}
+		if w.Weight < 0 {
+			invalid("failure weight %d must not be negative, got %v", i, w.Weight)
+		}
+	}
+	if s.PanicPolicy != PanicPropagate && s.PanicPolicy != PanicReturnError {
+		invalid("unknown panic policy %v", s.PanicPolicy)
+	}
+	if s.CallTimeout < 0 {
+		invalid("call timeout must not be negative, got %v", s.CallTimeout)
+	}
+	if s.FailureScoreHalfLife < 0 {
+		invalid("failure score half-life must not be negative, got %v", s.FailureScoreHalfLife)
+	}
+	if s.RampUp < 0 {
+		invalid("ramp-up must not be negative, got %v", s.RampUp)
+	}
+	if s.RampUpStrategy != LinearRampUp && s.RampUpStrategy != ExponentialRampUp {
+		invalid("unknown ramp-up strategy %v", s.RampUpStrategy)
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateThreshold checks a failure threshold, recursing into composites.
+func (s Settings) validateThreshold(t FailureThresholdType, invalid func(format string, args ...any)) {
+	switch threshold := t.(type) {
+	case ConsecutiveFailuresThreshold:
+		if threshold.Threshold == 0 {
+			invalid("consecutive failures threshold must be at least 1")
+		}
+	case LatencyThreshold:
+		if threshold.Percentile <= 0 || threshold.Percentile > 100 {
+			invalid("latency percentile must be in (0, 100], got %v", threshold.Percentile)
+		}
+		if threshold.Limit <= 0 {
+			invalid("latency limit must be positive, got %v", threshold.Limit)
+		}
+	case FailureScoreThreshold:
+		if threshold.Score <= 0 || threshold.Score > 1 {
+			invalid("failure score must be in (0, 1], got %v", threshold.Score)
+		}
+		if threshold.MinimumVolume < 0 {
+			invalid("failure score minimum volume must not be negative, got %v", threshold.MinimumVolume)
+		}
+	case FailureRateThreshold:
+		if threshold.Rate <= 0 || threshold.Rate > 1 {
+			invalid("failure rate must be in (0, 1], got %v", threshold.Rate)
+		}
+		switch s.WindowType {
+		case TimeWindow:
+			if s.RollingWindow <= 0 {
+				invalid("rolling window must be positive when using a failure rate threshold")
+			}
+		case CountWindow:
+			if s.WindowCount == 0 {
+				invalid("window count must be positive when using a count window")
+			}
+		default:
+			invalid("unknown window type %v", s.WindowType)
+		}
+	case CompositeThreshold:
+		if len(threshold.Thresholds) == 0 {
+			invalid("composite threshold must combine at least one threshold")
+		}
+		for _, sub := range threshold.Thresholds {
+			if sub == nil {
+				invalid("composite threshold must not contain a nil threshold")
+				continue
+			}
+			s.validateThreshold(sub, invalid)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package gomian
 
 import (
 	"errors"
+	"strings"
 	"testing"
 	"time"
 )
@@ -159,3 +160,85 @@ func TestIgnoredErrors(t *testing.T) {
 		t.Error("isFailure should return true for non-ignored error")
 	}
 }
+
+func TestWindowTypeString(t *testing.T) {
+	tests := []struct {
+		windowType WindowType
+		expected   string
+	}{
+		{TimeWindow, "TimeWindow"},
+		{CountWindow, "CountWindow"},
+		{WindowType(42), "Unknown WindowType(42)"},
+	}
+
+	for _, tt := range tests {
+		if tt.windowType.String() != tt.expected {
+			t.Errorf("WindowType.String() should be %q, got %q", tt.expected, tt.windowType.String())
+		}
+	}
+}
+
+func TestValidateCountWindow(t *testing.T) {
+	settings := DefaultSettings()
+	settings.FailureThreshold = NewFailureRateThreshold(0.5, 10)
+	settings.WindowType = CountWindow
+	settings.RollingWindow = 0
+
+	if err := settings.Validate(); !errors.Is(err, ErrInvalidSettings) {
+		t.Errorf("Count window without a size should be invalid, got: %v", err)
+	}
+
+	settings.WindowCount = 20
+	if err := settings.Validate(); err != nil {
+		t.Errorf("Count window should not require a rolling window, got: %v", err)
+	}
+}
+
+func TestValidateCompositeThreshold(t *testing.T) {
+	settings := DefaultSettings()
+	settings.FailureThreshold = AnyOf()
+	if err := settings.Validate(); !errors.Is(err, ErrInvalidSettings) {
+		t.Errorf("An empty composite should be invalid, got %v", err)
+	}
+
+	settings.FailureThreshold = AnyOf(ConsecutiveFailures(0), AllOf(NewFailureRateThreshold(2, 0)))
+	err := settings.Validate()
+	if !errors.Is(err, ErrInvalidSettings) {
+		t.Fatalf("Invalid sub-thresholds should be reported, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "consecutive failures") || !strings.Contains(err.Error(), "failure rate") {
+		t.Errorf("Both nested problems should be reported, got %v", err)
+	}
+
+	settings.FailureThreshold = AnyOf(ConsecutiveFailures(5), NewFailureRateThreshold(0.5, 10))
+	if err := settings.Validate(); err != nil {
+		t.Errorf("A valid composite should pass validation, got %v", err)
+	}
+}
+
+func TestValidateFailureScore(t *testing.T) {
+	settings := DefaultSettings()
+	settings.FailureThreshold = NewFailureScoreThreshold(1.5, -1)
+	settings.FailureScoreHalfLife = -time.Second
+	err := settings.Validate()
+	if !errors.Is(err, ErrInvalidSettings) {
+		t.Fatalf("Invalid failure score settings should be rejected, got %v", err)
+	}
+	for _, want := range []string{"failure score must", "minimum volume", "half-life"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Error should mention %q, got %v", want, err)
+		}
+	}
+}
+
+func TestValidateFailureWeights(t *testing.T) {
+	settings := DefaultSettings()
+	settings.FailureWeights = []ErrorWeight{{Weight: 1}, {Match: ErrorIs(errors.ErrUnsupported), Weight: -1}}
+	err := settings.Validate()
+	if !errors.Is(err, ErrInvalidSettings) {
+		t.Fatalf("Invalid failure weights should be rejected, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "matcher") || !strings.Contains(err.Error(), "negative") {
+		t.Errorf("Both problems should be reported, got %v", err)
+	}
+}
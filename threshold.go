@@ -0,0 +1,342 @@
+package gomian
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nutcase/gomian/internal/counter"
+)
+
+// WindowSnapshot is the view of a circuit breaker's counters that a threshold
+// is evaluated against.
+type WindowSnapshot struct {
+	// Failures, Successes and Total are the counts within the failure rate
+	// window, or since the last reset if the breaker keeps no window.
+	Failures  uint64
+	Successes uint64
+	Total     uint64
+
+	// ConsecutiveFailures and ConsecutiveSuccesses are the current streaks.
+	ConsecutiveFailures  uint64
+	ConsecutiveSuccesses uint64
+
+	// WeightedFailures and WeightedConsecutiveFailures are the sums of the
+	// weights of the failures counted by Failures and ConsecutiveFailures, as
+	// assigned by Settings.FailureWeight or FailureWeights. The built-in
+	// thresholds use them in place of the plain counts; if zero, the plain
+	// counts are used.
+	WeightedFailures            float64
+	WeightedConsecutiveFailures float64
+
+	// Window is the duration of the time window, or zero for a count window.
+	Window time.Duration
+
+	// MinimumRequestVolume is the configured minimum number of requests
+	// before a rate-based threshold applies.
+	MinimumRequestVolume uint64
+
+	// FailureScore is the exponentially weighted failure score between 0 and 1,
+	// and FailureScoreVolume the decayed request volume it is based on. Both
+	// are zero unless the breaker tracks a failure score.
+	FailureScore       float64
+	FailureScoreVolume float64
+
+	latency *counter.LatencyHistogram
+}
+
+// LatencyPercentile returns the p-th percentile of call latency within the
+// latency window and the number of samples it is based on. Both are zero if
+// the breaker does not record latency.
+func (s WindowSnapshot) LatencyPercentile(p float64) (time.Duration, uint64) {
+	if s.latency == nil {
+		return 0, 0
+	}
+	return s.latency.Percentile(p)
+}
+
+// failureWeight returns the weighted failures, falling back to the plain count.
+func (s WindowSnapshot) failureWeight() float64 {
+	if s.WeightedFailures > 0 {
+		return s.WeightedFailures
+	}
+	return float64(s.Failures)
+}
+
+// consecutiveFailureWeight returns the weighted consecutive failures,
+// falling back to the plain count.
+func (s WindowSnapshot) consecutiveFailureWeight() float64 {
+	if s.WeightedConsecutiveFailures > 0 {
+		return s.WeightedConsecutiveFailures
+	}
+	return float64(s.ConsecutiveFailures)
+}
+
+// SnapshotThreshold is a FailureThresholdType that is evaluated against a full
+// WindowSnapshot instead of the plain counts passed to ShouldTrip. The circuit
+// breaker prefers ShouldTripSnapshot whenever a threshold implements it.
+type SnapshotThreshold interface {
+	FailureThresholdType
+	// ShouldTripSnapshot evaluates whether the circuit should trip.
+	ShouldTripSnapshot(s WindowSnapshot) bool
+}
+
+// AdaptThreshold returns t as a SnapshotThreshold. Thresholds that only
+// implement ShouldTrip are wrapped so that they receive the window counts.
+func AdaptThreshold(t FailureThresholdType) SnapshotThreshold {
+	if st, ok := t.(SnapshotThreshold); ok {
+		return st
+	}
+	return legacyThreshold{t}
+}
+
+// legacyThreshold adapts a FailureThresholdType implementing only ShouldTrip.
+type legacyThreshold struct {
+	FailureThresholdType
+}
+
+// ShouldTripSnapshot calls ShouldTrip with the window counts.
+func (l legacyThreshold) ShouldTripSnapshot(s WindowSnapshot) bool {
+	return l.ShouldTrip(s.Failures, s.Successes, s.Total, s.Window)
+}
+
+// ShouldTripSnapshot returns true if the weight of the consecutive failures reaches the threshold.
+func (c ConsecutiveFailuresThreshold) ShouldTripSnapshot(s WindowSnapshot) bool {
+	return s.consecutiveFailureWeight() >= float64(c.Threshold)
+}
+
+// ShouldTripSnapshot returns true if the weighted failure rate within the window
+// reaches the threshold once the minimum request volume is met.
+func (f FailureRateThreshold) ShouldTripSnapshot(s WindowSnapshot) bool {
+	if s.Total == 0 || s.Total < s.MinimumRequestVolume || s.Total < f.Samples {
+		return false
+	}
+	return s.failureWeight()/float64(s.Total) >= f.Rate
+}
+
+// LatencyThreshold represents a threshold based on a latency percentile within the latency window.
+// Slow calls trip the circuit even when they succeed.
+type LatencyThreshold struct {
+	// Percentile is the latency percentile to watch, e.g. 99 for p99.
+	Percentile float64
+	// Limit is the latency above which the circuit trips.
+	Limit time.Duration
+	// Samples is the minimum number of latency samples before the threshold applies.
+	Samples uint64
+}
+
+// ShouldTrip always returns false: a latency threshold cannot be evaluated from counts alone.
+func (l LatencyThreshold) ShouldTrip(_, _, _ uint64, _ time.Duration) bool {
+	return false
+}
+
+// ShouldTripSnapshot returns true if the latency percentile exceeds the limit
+// and the minimum sample count is met.
+func (l LatencyThreshold) ShouldTripSnapshot(s WindowSnapshot) bool {
+	latency, samples := s.LatencyPercentile(l.Percentile)
+	return samples > 0 && samples >= l.Samples && latency > l.Limit
+}
+
+// String returns a string representation of the LatencyThreshold.
+func (l LatencyThreshold) String() string {
+	return fmt.Sprintf("LatencyP%v", l.Percentile)
+}
+
+// NewLatencyThreshold creates a new LatencyThreshold tripping when the given
+// latency percentile exceeds limit over at least samples calls.
+func NewLatencyThreshold(percentile float64, limit time.Duration, samples uint64) FailureThresholdType {
+	return LatencyThreshold{Percentile: percentile, Limit: limit, Samples: samples}
+}
+
+// FailureScoreThreshold represents a threshold based on an exponentially
+// weighted moving average of failures. Recent outcomes weigh the most, and
+// older ones fade out gradually as set by Settings.FailureScoreHalfLife, so
+// the score has no cliffs when old outcomes expire.
+type FailureScoreThreshold struct {
+	// Score is the failure score, between 0 and 1, at which the circuit trips.
+	Score float64
+	// MinimumVolume is the minimum decayed request volume before the threshold applies.
+	MinimumVolume float64
+}
+
+// ShouldTrip always returns false: a failure score cannot be evaluated from counts alone.
+func (f FailureScoreThreshold) ShouldTrip(_, _, _ uint64, _ time.Duration) bool {
+	return false
+}
+
+// ShouldTripSnapshot returns true if the failure score reaches the threshold
+// and the minimum volume is met.
+func (f FailureScoreThreshold) ShouldTripSnapshot(s WindowSnapshot) bool {
+	return s.FailureScoreVolume > 0 && s.FailureScoreVolume >= f.MinimumVolume && s.FailureScore >= f.Score
+}
+
+// String returns a string representation of the FailureScoreThreshold.
+func (f FailureScoreThreshold) String() string {
+	return "FailureScore"
+}
+
+// NewFailureScoreThreshold creates a new FailureScoreThreshold tripping when
+// the failure score reaches score over a decayed volume of at least minimumVolume requests.
+func NewFailureScoreThreshold(score, minimumVolume float64) FailureThresholdType {
+	return FailureScoreThreshold{Score: score, MinimumVolume: minimumVolume}
+}
+
+// usesWindow reports whether evaluating t requires a failure rate window.
+func usesWindow(t FailureThresholdType) bool {
+	switch t := t.(type) {
+	case FailureRateThreshold:
+		return true
+	case CompositeThreshold:
+		return anyThreshold(t.Thresholds, usesWindow)
+	default:
+		return false
+	}
+}
+
+// usesLatency reports whether evaluating t requires a latency histogram.
+func usesLatency(t FailureThresholdType) bool {
+	switch t := t.(type) {
+	case LatencyThreshold:
+		return true
+	case CompositeThreshold:
+		return anyThreshold(t.Thresholds, usesLatency)
+	default:
+		return false
+	}
+}
+
+// usesFailureScore reports whether evaluating t requires a failure score.
+func usesFailureScore(t FailureThresholdType) bool {
+	switch t := t.(type) {
+	case FailureScoreThreshold:
+		return true
+	case CompositeThreshold:
+		return anyThreshold(t.Thresholds, usesFailureScore)
+	default:
+		return false
+	}
+}
+
+// tripsOnFailureOnly reports whether t can only start tripping after a failure,
+// so that it does not need to be evaluated after successful calls.
+func tripsOnFailureOnly(t FailureThresholdType) bool {
+	switch t := t.(type) {
+	case ConsecutiveFailuresThreshold, FailureRateThreshold, FailureScoreThreshold:
+		return true
+	case CompositeThreshold:
+		return !anyThreshold(t.Thresholds, func(t FailureThresholdType) bool {
+			return !tripsOnFailureOnly(t)
+		})
+	default:
+		return false
+	}
+}
+
+// anyThreshold reports whether pred holds for any of thresholds.
+func anyThreshold(thresholds []FailureThresholdType, pred func(FailureThresholdType) bool) bool {
+	for _, t := range thresholds {
+		if pred(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// CompositeThreshold combines several thresholds. With RequireAll unset it
+// trips as soon as any of them does; with RequireAll set, only when all of
+// them do.
+type CompositeThreshold struct {
+	Thresholds []FailureThresholdType
+	RequireAll bool
+}
+
+// AnyOf creates a CompositeThreshold that trips when any of the given thresholds does.
+func AnyOf(thresholds ...FailureThresholdType) FailureThresholdType {
+	return CompositeThreshold{Thresholds: thresholds}
+}
+
+// AllOf creates a CompositeThreshold that trips only when all of the given thresholds do.
+func AllOf(thresholds ...FailureThresholdType) FailureThresholdType {
+	return CompositeThreshold{Thresholds: thresholds, RequireAll: true}
+}
+
+// ShouldTrip combines the ShouldTrip results of the sub-thresholds.
+func (c CompositeThreshold) ShouldTrip(failures, successes, total uint64, window time.Duration) bool {
+	return c.combine(func(t FailureThresholdType) bool {
+		return t.ShouldTrip(failures, successes, total, window)
+	}) != nil
+}
+
+// ShouldTripSnapshot combines the results of the sub-thresholds evaluated against s.
+func (c CompositeThreshold) ShouldTripSnapshot(s WindowSnapshot) bool {
+	trip, _ := c.Evaluate(s)
+	return trip
+}
+
+// Evaluate reports whether the composite trips and which sub-thresholds fired.
+// Nested composites report their own sub-thresholds, so the result only
+// contains leaf thresholds.
+func (c CompositeThreshold) Evaluate(s WindowSnapshot) (bool, []FailureThresholdType) {
+	var fired []FailureThresholdType
+	trip := c.combine(func(t FailureThresholdType) bool {
+		trip, trippedBy := evaluateThreshold(AdaptThreshold(t), s)
+		if trip {
+			fired = append(fired, trippedBy...)
+		}
+		return trip
+	}) != nil
+	if !trip {
+		return false, nil
+	}
+	return true, fired
+}
+
+// combine applies eval to the sub-thresholds with AND or OR semantics and
+// returns the sub-thresholds that fired, or nil if the composite does not trip.
+// OR evaluation stops at the first threshold that fires.
+func (c CompositeThreshold) combine(eval func(FailureThresholdType) bool) []FailureThresholdType {
+	if len(c.Thresholds) == 0 {
+		return nil
+	}
+
+	var fired []FailureThresholdType
+	for _, t := range c.Thresholds {
+		if eval(t) {
+			fired = append(fired, t)
+			if !c.RequireAll {
+				return fired
+			}
+		} else if c.RequireAll {
+			return nil
+		}
+	}
+	return fired
+}
+
+// String returns a string representation of the CompositeThreshold.
+func (c CompositeThreshold) String() string {
+	names := make([]string, len(c.Thresholds))
+	for i, t := range c.Thresholds {
+		names[i] = t.String()
+	}
+
+	op := "AnyOf"
+	if c.RequireAll {
+		op = "AllOf"
+	}
+	return op + "(" + strings.Join(names, ", ") + ")"
+}
+
+// evaluateThreshold evaluates t against s and returns the leaf thresholds that fired.
+func evaluateThreshold(t SnapshotThreshold, s WindowSnapshot) (bool, []FailureThresholdType) {
+	if c, ok := t.(CompositeThreshold); ok {
+		return c.Evaluate(s)
+	}
+	if l, ok := t.(legacyThreshold); ok {
+		return l.ShouldTripSnapshot(s), []FailureThresholdType{l.FailureThresholdType}
+	}
+	if !t.ShouldTripSnapshot(s) {
+		return false, nil
+	}
+	return true, []FailureThresholdType{t}
+}
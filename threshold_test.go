@@ -0,0 +1,503 @@
+package gomian
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nutcase/gomian/clocktest"
+	"github.com/nutcase/gomian/internal/counter"
+)
+
+// totalFailuresThreshold is a threshold written against the original
+// ShouldTrip signature only.
+type totalFailuresThreshold struct {
+	max uint64
+}
+
+func (t totalFailuresThreshold) ShouldTrip(failures, _, _ uint64, _ time.Duration) bool {
+	return failures >= t.max
+}
+
+func (t totalFailuresThreshold) String() string {
+	return "TotalFailures"
+}
+
+func TestAdaptThreshold(t *testing.T) {
+	// Built-in thresholds are returned unchanged
+	if _, ok := AdaptThreshold(ConsecutiveFailures(3)).(ConsecutiveFailuresThreshold); !ok {
+		t.Error("AdaptThreshold should return built-in thresholds unchanged")
+	}
+
+	// Legacy thresholds receive the window counts
+	adapted := AdaptThreshold(totalFailuresThreshold{max: 2})
+	if adapted.String() != "TotalFailures" {
+		t.Errorf("Adapted threshold should keep its name, got %q", adapted.String())
+	}
+	if adapted.ShouldTripSnapshot(WindowSnapshot{Failures: 1, Total: 5}) {
+		t.Error("Should not trip below the legacy threshold")
+	}
+	if !adapted.ShouldTripSnapshot(WindowSnapshot{Failures: 2, Total: 5}) {
+		t.Error("Should trip at the legacy threshold")
+	}
+}
+
+func TestBuiltinThresholdSnapshots(t *testing.T) {
+	consecutive := ConsecutiveFailures(3).(SnapshotThreshold)
+	if consecutive.ShouldTripSnapshot(WindowSnapshot{ConsecutiveFailures: 2, Failures: 10}) {
+		t.Error("Consecutive threshold should use the consecutive failures, not the window failures")
+	}
+	if !consecutive.ShouldTripSnapshot(WindowSnapshot{ConsecutiveFailures: 3}) {
+		t.Error("Consecutive threshold should trip at the threshold")
+	}
+
+	rate := NewFailureRateThreshold(0.5, 0).(SnapshotThreshold)
+	if rate.ShouldTripSnapshot(WindowSnapshot{Failures: 4, Total: 4, MinimumRequestVolume: 5}) {
+		t.Error("Failure rate threshold should respect the minimum request volume")
+	}
+	if !rate.ShouldTripSnapshot(WindowSnapshot{Failures: 3, Total: 5, MinimumRequestVolume: 5}) {
+		t.Error("Failure rate threshold should trip at the rate once the volume is met")
+	}
+}
+
+func TestLatencyThreshold(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	h := counter.NewLatencyHistogram(10*time.Second, 10, clk)
+	threshold := NewLatencyThreshold(99, 800*time.Millisecond, 50).(SnapshotThreshold)
+
+	if threshold.String() != "LatencyP99" {
+		t.Errorf("String should be 'LatencyP99', got %q", threshold.String())
+	}
+
+	// Without latency data the threshold never trips
+	if threshold.ShouldTripSnapshot(WindowSnapshot{}) {
+		t.Error("Should not trip without latency samples")
+	}
+	if threshold.ShouldTrip(100, 0, 100, time.Second) {
+		t.Error("ShouldTrip cannot evaluate latency and should return false")
+	}
+
+	// Too few samples
+	for i := 0; i < 49; i++ {
+		h.Record(time.Second)
+	}
+	if threshold.ShouldTripSnapshot(WindowSnapshot{latency: h}) {
+		t.Error("Should not trip below the minimum sample count")
+	}
+
+	h.Record(time.Second)
+	if !threshold.ShouldTripSnapshot(WindowSnapshot{latency: h}) {
+		t.Error("Should trip when p99 exceeds the limit with enough samples")
+	}
+
+	// Fast samples pull the percentile under the limit
+	h.Reset()
+	for i := 0; i < 100; i++ {
+		h.Record(10 * time.Millisecond)
+	}
+	if threshold.ShouldTripSnapshot(WindowSnapshot{latency: h}) {
+		t.Error("Should not trip when p99 is under the limit")
+	}
+}
+
+func TestCircuitBreakerLatencyThreshold(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb, err := New("TestBreaker",
+		WithLatencyThreshold(99, 800*time.Millisecond, 50),
+		WithLatencyWindow(30*time.Second, 10),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got error: %v", err)
+	}
+	defer cb.Close()
+
+	call := func(d time.Duration) error {
+		return cb.Execute(func() error {
+			clk.Advance(d)
+			return nil
+		})
+	}
+
+	// Fast calls never trip
+	for i := 0; i < 60; i++ {
+		call(10 * time.Millisecond)
+	}
+	if cb.State() != Closed {
+		t.Fatalf("Circuit should stay closed with fast calls, got %v", cb.State())
+	}
+
+	// A few slow but successful calls push p99 over the limit
+	var tripped bool
+	cb.OnTrip(func(name string, err error) {
+		tripped = true
+	})
+	for i := 0; i < 5 && cb.State() == Closed; i++ {
+		call(time.Second)
+	}
+
+	if cb.State() != Open {
+		t.Errorf("Circuit should trip on slow successful calls, got %v", cb.State())
+	}
+	if !tripped {
+		t.Error("Trip callback should be called")
+	}
+}
+
+func TestCircuitBreakerLatencyThresholdRecovery(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb, err := New("TestBreaker",
+		WithLatencyThreshold(50, 100*time.Millisecond, 10),
+		WithLatencyWindow(time.Minute, 10),
+		WithTimeout(time.Second),
+		WithSuccessThreshold(1),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got error: %v", err)
+	}
+	defer cb.Close()
+
+	call := func(d time.Duration) error {
+		return cb.Execute(func() error {
+			clk.Advance(d)
+			return nil
+		})
+	}
+
+	for i := 0; i < 10; i++ {
+		call(time.Second)
+	}
+	if cb.State() != Open {
+		t.Fatalf("Circuit should trip on slow calls, got %v", cb.State())
+	}
+
+	// A fast probe closes the circuit, and the slow samples from before the
+	// trip must not trip it again
+	clk.Advance(time.Second)
+	if err := call(time.Millisecond); err != nil {
+		t.Fatalf("Probe should succeed, got %v", err)
+	}
+	call(time.Millisecond)
+	if cb.State() != Closed {
+		t.Errorf("Circuit should stay closed after recovering, got %v", cb.State())
+	}
+	if samples := cb.GetMetrics().LatencySamples; samples != 1 {
+		t.Errorf("Only the call made after closing should be sampled, got %d", samples)
+	}
+}
+
+func TestCircuitBreakerLegacyThreshold(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{
+		Name:             "TestBreaker",
+		FailureThreshold: totalFailuresThreshold{max: 3},
+		SuccessThreshold: 1,
+		Timeout:          time.Hour,
+	})
+	defer cb.Close()
+
+	// Successes in between do not reset a total-failures threshold
+	for i := 0; i < 3; i++ {
+		cb.Execute(func() error { return errors.New("failure") })
+		cb.Execute(func() error { return nil })
+	}
+
+	if cb.State() != Open {
+		t.Errorf("Circuit should trip via the adapted legacy threshold, got %v", cb.State())
+	}
+}
+
+func TestCompositeThreshold(t *testing.T) {
+	consecutive := ConsecutiveFailures(3)
+	rate := NewFailureRateThreshold(0.6, 0)
+
+	anyOf := AnyOf(consecutive, rate).(CompositeThreshold)
+	allOf := AllOf(consecutive, rate).(CompositeThreshold)
+
+	if anyOf.String() != "AnyOf(ConsecutiveFailures, FailureRate)" {
+		t.Errorf("Unexpected AnyOf string: %q", anyOf.String())
+	}
+	if allOf.String() != "AllOf(ConsecutiveFailures, FailureRate)" {
+		t.Errorf("Unexpected AllOf string: %q", allOf.String())
+	}
+
+	tests := []struct {
+		name        string
+		snapshot    WindowSnapshot
+		anyTrips    bool
+		anyTripedBy []string
+		allTrips    bool
+	}{
+		{"neither", WindowSnapshot{ConsecutiveFailures: 1, Failures: 1, Total: 10}, false, nil, false},
+		{"consecutive only", WindowSnapshot{ConsecutiveFailures: 3, Failures: 3, Total: 10}, true, []string{"ConsecutiveFailures"}, false},
+		{"rate only", WindowSnapshot{ConsecutiveFailures: 1, Failures: 7, Total: 10}, true, []string{"FailureRate"}, false},
+		{"both", WindowSnapshot{ConsecutiveFailures: 5, Failures: 7, Total: 10}, true, []string{"ConsecutiveFailures"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trip, trippedBy := anyOf.Evaluate(tt.snapshot)
+			if trip != tt.anyTrips {
+				t.Errorf("AnyOf should return %v, got %v", tt.anyTrips, trip)
+			}
+			if len(trippedBy) != len(tt.anyTripedBy) {
+				t.Fatalf("AnyOf should report %v, got %v", tt.anyTripedBy, trippedBy)
+			}
+			for i, name := range tt.anyTripedBy {
+				if trippedBy[i].String() != name {
+					t.Errorf("AnyOf should report %v, got %v", tt.anyTripedBy, trippedBy)
+				}
+			}
+
+			trip, trippedBy = allOf.Evaluate(tt.snapshot)
+			if trip != tt.allTrips {
+				t.Errorf("AllOf should return %v, got %v", tt.allTrips, trip)
+			}
+			if trip && len(trippedBy) != 2 {
+				t.Errorf("AllOf should report both thresholds, got %v", trippedBy)
+			}
+		})
+	}
+
+	// Legacy evaluation combines ShouldTrip
+	if !anyOf.ShouldTrip(3, 0, 10, 0) {
+		t.Error("AnyOf.ShouldTrip should trip when one threshold does")
+	}
+	if allOf.ShouldTrip(3, 7, 10, 0) {
+		t.Error("AllOf.ShouldTrip should not trip when one threshold does not")
+	}
+
+	// An empty composite never trips
+	if trip, _ := (CompositeThreshold{}).Evaluate(WindowSnapshot{ConsecutiveFailures: 100}); trip {
+		t.Error("An empty composite should not trip")
+	}
+}
+
+func TestNestedCompositeThreshold(t *testing.T) {
+	latency := NewLatencyThreshold(99, time.Second, 1)
+	nested := AnyOf(latency, AllOf(ConsecutiveFailures(2), totalFailuresThreshold{max: 5}))
+
+	if !usesLatency(nested) {
+		t.Error("Nested composite should require latency")
+	}
+	if usesWindow(nested) {
+		t.Error("Nested composite should not require a failure rate window")
+	}
+	if tripsOnFailureOnly(nested) {
+		t.Error("Nested composite with a latency threshold should be evaluated on success")
+	}
+
+	trip, trippedBy := nested.(CompositeThreshold).Evaluate(WindowSnapshot{ConsecutiveFailures: 2, Failures: 5})
+	if !trip {
+		t.Fatal("Nested AllOf should trip")
+	}
+	if len(trippedBy) != 2 || trippedBy[0].String() != "ConsecutiveFailures" || trippedBy[1].String() != "TotalFailures" {
+		t.Errorf("Should report the leaf thresholds, got %v", trippedBy)
+	}
+}
+
+func TestCircuitBreakerCompositeTripEvent(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	threshold := AnyOf(ConsecutiveFailures(10), NewFailureRateThreshold(0.6, 0))
+	cb := NewCircuitBreaker(Settings{
+		Name:                 "TestBreaker",
+		FailureThreshold:     threshold,
+		SuccessThreshold:     1,
+		Timeout:              time.Hour,
+		RollingWindow:        30 * time.Second,
+		MinimumRequestVolume: 10,
+		Clock:                clk,
+	})
+	defer cb.Close()
+
+	var events []TripEvent
+	trips := 0
+	cb.OnTripEvent(func(event TripEvent) {
+		events = append(events, event)
+	})
+	cb.OnTrip(func(name string, err error) {
+		trips++
+	})
+
+	// Alternate so that failures are never consecutive but the rate climbs to 60%
+	testErr := errors.New("failure")
+	for i := 0; i < 10 && cb.State() == Closed; i++ {
+		cb.Execute(func() error {
+			if i%5 == 1 || i%5 == 3 {
+				return nil
+			}
+			return testErr
+		})
+	}
+
+	if cb.State() != Open {
+		t.Fatalf("Circuit should trip on the failure rate, got %v", cb.State())
+	}
+	if trips != 1 {
+		t.Errorf("Trip callback should be called once, got %d", trips)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Should receive one trip event, got %d", len(events))
+	}
+
+	event := events[0]
+	if event.Name != "TestBreaker" || !errors.Is(event.Err, testErr) {
+		t.Errorf("Trip event should carry the name and error, got %+v", event)
+	}
+	if event.Threshold.String() != threshold.String() {
+		t.Errorf("Trip event should carry the configured threshold, got %v", event.Threshold)
+	}
+	if len(event.TrippedBy) != 1 || event.TrippedBy[0].String() != "FailureRate" {
+		t.Errorf("Trip event should report the failure rate threshold, got %v", event.TrippedBy)
+	}
+}
+
+func TestFailureScoreThreshold(t *testing.T) {
+	threshold := NewFailureScoreThreshold(0.5, 10).(FailureScoreThreshold)
+
+	if threshold.String() != "FailureScore" {
+		t.Errorf("Unexpected string: %q", threshold.String())
+	}
+	if threshold.ShouldTrip(100, 0, 100, time.Second) {
+		t.Error("ShouldTrip cannot evaluate a failure score and should return false")
+	}
+
+	tests := []struct {
+		name     string
+		score    float64
+		volume   float64
+		expected bool
+	}{
+		{"untracked", 0, 0, false},
+		{"below volume", 0.9, 9.5, false},
+		{"below score", 0.4, 20, false},
+		{"at score", 0.5, 10, true},
+		{"above score", 0.8, 50, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := threshold.ShouldTripSnapshot(WindowSnapshot{FailureScore: tt.score, FailureScoreVolume: tt.volume})
+			if result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerFailureScore(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	cb, err := New("TestBreaker",
+		WithFailureScore(0.5, 5, 10*time.Second),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	testErr := errors.New("failure")
+
+	// A failure among old successes barely moves the score
+	for i := 0; i < 20; i++ {
+		cb.Execute(func() error { return nil })
+	}
+	cb.Execute(func() error { return testErr })
+	if score := cb.GetMetrics().FailureScore; score <= 0 || score >= 0.1 {
+		t.Errorf("Score should be small but positive, got %v", score)
+	}
+
+	// Long after the successes, recent failures dominate the score
+	clk.Advance(time.Minute)
+	for i := 0; i < 5 && cb.State() == Closed; i++ {
+		cb.Execute(func() error { return testErr })
+	}
+	if cb.State() != Open {
+		t.Fatalf("Circuit should trip on the failure score, got %v (score %v)", cb.State(), cb.GetMetrics().FailureScore)
+	}
+	if score := cb.GetMetrics().FailureScore; score < 0.5 {
+		t.Errorf("Score should have reached the threshold, got %v", score)
+	}
+}
+
+func TestWeightedThresholds(t *testing.T) {
+	consecutive := ConsecutiveFailures(3).(SnapshotThreshold)
+	rate := NewFailureRateThreshold(0.5, 0).(SnapshotThreshold)
+
+	// Two heavy failures weigh more than three plain ones
+	s := WindowSnapshot{ConsecutiveFailures: 2, WeightedConsecutiveFailures: 4, Failures: 2, WeightedFailures: 4, Total: 6}
+	if !consecutive.ShouldTripSnapshot(s) {
+		t.Error("Consecutive threshold should use the weighted streak")
+	}
+	if !rate.ShouldTripSnapshot(s) {
+		t.Error("Failure rate threshold should use the weighted failures")
+	}
+
+	// Light failures weigh less than their count
+	s = WindowSnapshot{ConsecutiveFailures: 4, WeightedConsecutiveFailures: 2, Failures: 4, WeightedFailures: 2, Total: 6}
+	if consecutive.ShouldTripSnapshot(s) || rate.ShouldTripSnapshot(s) {
+		t.Error("Light failures should not trip the thresholds")
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "timeout" }
+
+func TestCircuitBreakerFailureWeights(t *testing.T) {
+	overloaded := errors.New("503 with Retry-After")
+	cb, err := New("TestBreaker",
+		WithConsecutiveFailures(4),
+		WithFailureWeights(
+			ErrorWeight{Match: ErrorAs[timeoutError](), Weight: 2},
+			ErrorWeight{Match: ErrorIs(overloaded), Weight: 0.5},
+		),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	// Overload responses count for half a failure each
+	for i := 0; i < 6; i++ {
+		cb.Execute(func() error { return fmt.Errorf("call: %w", overloaded) })
+	}
+	if cb.State() != Closed {
+		t.Fatalf("Six light failures should not trip the circuit, got %v", cb.State())
+	}
+
+	// One timeout on top of them does
+	cb.Execute(func() error { return fmt.Errorf("call: %w", timeoutError{}) })
+	if cb.State() != Open {
+		t.Fatalf("A timeout should trip the circuit, got %v", cb.State())
+	}
+}
+
+func TestCircuitBreakerFailureWeightFunc(t *testing.T) {
+	ignorable := errors.New("ignorable")
+	cb, err := New("TestBreaker",
+		WithCountWindow(0.5, 4, 4),
+		WithFailureWeightFunc(func(err error) float64 {
+			if errors.Is(err, ignorable) {
+				return 0
+			}
+			return 1
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New should succeed, got %v", err)
+	}
+	defer cb.Close()
+
+	// A weight of zero records the call as a success
+	for i := 0; i < 4; i++ {
+		cb.Execute(func() error { return ignorable })
+	}
+	if cb.State() != Closed {
+		t.Fatalf("Zero-weight failures should not trip the circuit, got %v", cb.State())
+	}
+	if metrics := cb.GetMetrics(); metrics.TotalRequests != 4 || metrics.TotalFailures != 0 {
+		t.Errorf("Zero-weight failures should count as successes, got %d requests and %d failures", metrics.TotalRequests, metrics.TotalFailures)
+	}
+}
@@ -0,0 +1,211 @@
+package gomian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/nutcase/gomian/internal/clock"
+	"github.com/nutcase/gomian/internal/counter"
+)
+
+// ThrottleSettings defines the configuration for an AdaptiveThrottle.
+type ThrottleSettings struct {
+	// Name is a unique identifier for this throttle.
+	Name string
+
+	// K is the multiplier applied to accepted requests. Requests are rejected
+	// locally once more than K requests are attempted per accepted one; lower
+	// values throttle more aggressively. 2 is a good starting point. Values
+	// below 1 would throttle a healthy backend, so NewAdaptiveThrottle raises
+	// them to 1.
+	K float64
+
+	// RollingWindow is the time window over which requests and accepts are counted.
+	RollingWindow time.Duration
+
+	// IsFailure is a custom function to determine if an error counts as a failure.
+	// If nil, any non-nil error is considered a failure.
+	IsFailure func(error) bool
+
+	// IgnoredErrors is a list of errors that do not count as failures,
+	// matched with errors.Is.
+	IgnoredErrors []error
+
+	// Clock is the source of time for the throttle's window.
+	// If nil, the real clock is used.
+	Clock Clock
+
+	// Rand returns a pseudo-random number in [0, 1) used to decide whether a
+	// request is rejected. If nil, math/rand/v2's Float64 is used.
+	Rand func() float64
+}
+
+// DefaultThrottleSettings returns a ThrottleSettings struct with sensible default values.
+func DefaultThrottleSettings() ThrottleSettings {
+	return ThrottleSettings{
+		Name:          "default",
+		K:             2,
+		RollingWindow: 2 * time.Minute,
+	}
+}
+
+// Validate checks the settings for inconsistent or out-of-range values.
+// All problems are reported together, each wrapping ErrInvalidSettings.
+func (s ThrottleSettings) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrInvalidSettings, fmt.Sprintf(format, args...)))
+	}
+
+	if s.Name == "" {
+		invalid("name must not be empty")
+	}
+	if s.K < 1 {
+		invalid("throttle multiplier must be at least 1, got %v", s.K)
+	}
+	if s.RollingWindow <= 0 {
+		invalid("rolling window must be positive, got %v", s.RollingWindow)
+	}
+
+	return errors.Join(errs...)
+}
+
+// AdaptiveThrottle implements client-side adaptive throttling as described in
+// the Google SRE book. Instead of switching between Closed and Open, it
+// rejects each request locally with probability
+//
+//	max(0, (requests - K*accepts) / (requests + 1))
+//
+// where requests counts every attempted request within the rolling window,
+// including those rejected locally, and accepts counts those the dependency
+// handled successfully. As the dependency fails, more traffic is shed, and
+// traffic recovers gradually as it starts succeeding again.
+type AdaptiveThrottle struct {
+	name      string
+	settings  ThrottleSettings
+	clock     clock.Clock
+	rand      func() float64
+	window    counter.Window
+	callbacks *Callbacks
+}
+
+// NewAdaptiveThrottle creates a new AdaptiveThrottle with the provided settings.
+func NewAdaptiveThrottle(settings ThrottleSettings) *AdaptiveThrottle {
+	defaults := DefaultThrottleSettings()
+	if settings.Name == "" {
+		settings.Name = defaults.Name
+	}
+	if settings.K <= 0 {
+		settings.K = defaults.K
+	}
+	settings.K = max(settings.K, 1)
+	if settings.RollingWindow <= 0 {
+		settings.RollingWindow = defaults.RollingWindow
+	}
+
+	t := &AdaptiveThrottle{
+		name:      settings.Name,
+		settings:  settings,
+		clock:     clock.OrReal(settings.Clock),
+		rand:      settings.Rand,
+		callbacks: NewCallbacks(),
+	}
+	if t.rand == nil {
+		t.rand = rand.Float64
+	}
+
+	// Requests that were not accepted, whether rejected locally or failed,
+	// are recorded as failures, so accepts are requests minus failures
+	t.window = counter.NewRollingWindowWithClock(settings.RollingWindow, 10, t.clock)
+
+	return t
+}
+
+// Execute executes the given function unless the request is throttled, in
+// which case it returns ErrThrottled without executing the function.
+func (t *AdaptiveThrottle) Execute(op func() error) error {
+	return t.ExecuteContext(context.Background(), func(ctx context.Context) error {
+		return op()
+	})
+}
+
+// ExecuteContext executes the given function with context unless the request
+// is throttled, in which case it returns ErrThrottled without executing the function.
+func (t *AdaptiveThrottle) ExecuteContext(ctx context.Context, op func(context.Context) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if p := t.RejectionProbability(); p > 0 && t.rand() < p {
+		t.window.IncrementFailure()
+		t.callbacks.NotifyRejection(t.name)
+		return ErrThrottled
+	}
+
+	// Any outcome other than a failure means the dependency accepted the request
+	err := op(ctx)
+	switch defaultOutcome(err, t.settings.IsFailure, t.settings.IgnoredErrors).Kind {
+	case KindFailure:
+		t.window.IncrementFailure()
+		t.callbacks.NotifyFailure(t.name, err)
+	case KindSuccess:
+		t.window.IncrementSuccess()
+		t.callbacks.NotifySuccess(t.name)
+	default:
+		t.window.IncrementSuccess()
+	}
+	return err
+}
+
+// RejectionProbability returns the probability with which the next request is rejected.
+func (t *AdaptiveThrottle) RejectionProbability() float64 {
+	requests, failures := t.window.Counts()
+	return rejectionProbability(requests, requests-failures, t.settings.K)
+}
+
+// rejectionProbability computes max(0, (requests - k*accepts) / (requests + 1)).
+func rejectionProbability(requests, accepts uint64, k float64) float64 {
+	p := (float64(requests) - k*float64(accepts)) / float64(requests+1)
+	if p < 0 {
+		return 0
+	}
+	return p
+}
+
+// OnSuccess registers a callback for successful requests.
+func (t *AdaptiveThrottle) OnSuccess(callback SuccessCallback) {
+	t.callbacks.AddOnSuccess(callback)
+}
+
+// OnFailure registers a callback for failed requests.
+func (t *AdaptiveThrottle) OnFailure(callback FailureCallback) {
+	t.callbacks.AddOnFailure(callback)
+}
+
+// OnRejection registers a callback for requests rejected by the throttle.
+func (t *AdaptiveThrottle) OnRejection(callback RejectionCallback) {
+	t.callbacks.AddOnRejection(callback)
+}
+
+// Name returns the name of the throttle.
+func (t *AdaptiveThrottle) Name() string {
+	return t.name
+}
+
+// GetMetrics returns the current metrics of the throttle. An AdaptiveThrottle
+// has no states, so State is always Closed. TotalRequests includes requests
+// rejected locally, and TotalFailures counts every request that was not accepted.
+func (t *AdaptiveThrottle) GetMetrics() Metrics {
+	requests, failures := t.window.Counts()
+
+	return Metrics{
+		Name:                 t.name,
+		State:                Closed,
+		TotalRequests:        requests,
+		TotalFailures:        failures,
+		RejectionProbability: rejectionProbability(requests, requests-failures, t.settings.K),
+	}
+}
@@ -0,0 +1,153 @@
+package gomian
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nutcase/gomian/clocktest"
+)
+
+func TestRejectionProbability(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests uint64
+		accepts  uint64
+		k        float64
+		want     float64
+	}{
+		{"no requests", 0, 0, 2, 0},
+		{"all accepted", 100, 100, 2, 0},
+		{"half accepted", 100, 50, 2, 0},
+		{"quarter accepted", 100, 25, 2, 50.0 / 101},
+		{"none accepted", 100, 0, 2, 100.0 / 101},
+		{"aggressive multiplier", 100, 50, 1, 50.0 / 101},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rejectionProbability(tt.requests, tt.accepts, tt.k)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Rejection probability should be %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAdaptiveThrottle(t *testing.T) {
+	clk := clocktest.NewFakeClock(time.Now())
+	roll := 0.5
+	throttle := NewAdaptiveThrottle(ThrottleSettings{
+		Name:          "TestThrottle",
+		K:             2,
+		RollingWindow: 10 * time.Second,
+		Clock:         clk,
+		Rand:          func() float64 { return roll },
+	})
+
+	var successes, failures, rejections int
+	throttle.OnSuccess(func(name string) { successes++ })
+	throttle.OnFailure(func(name string, err error) { failures++ })
+	throttle.OnRejection(func(name string) { rejections++ })
+
+	// While requests are accepted nothing is throttled
+	for i := 0; i < 10; i++ {
+		if err := throttle.Execute(func() error { return nil }); err != nil {
+			t.Fatalf("Request should succeed, got %v", err)
+		}
+	}
+	if p := throttle.RejectionProbability(); p != 0 {
+		t.Errorf("Rejection probability should be 0, got %v", p)
+	}
+
+	// Failures raise the rejection probability until requests are rejected
+	testErr := errors.New("failure")
+	var err error
+	for i := 0; i < 100 && !errors.Is(err, ErrThrottled); i++ {
+		err = throttle.Execute(func() error { return testErr })
+	}
+	if !errors.Is(err, ErrThrottled) {
+		t.Fatal("Requests should eventually be throttled")
+	}
+	if successes != 10 || failures == 0 || rejections != 1 {
+		t.Errorf("Unexpected callback counts: successes=%d failures=%d rejections=%d", successes, failures, rejections)
+	}
+
+	metrics := throttle.GetMetrics()
+	if metrics.Name != "TestThrottle" || metrics.State != Closed {
+		t.Errorf("Unexpected metrics: %+v", metrics)
+	}
+	if metrics.RejectionProbability <= roll {
+		t.Errorf("Rejection probability should exceed %v, got %v", roll, metrics.RejectionProbability)
+	}
+	if metrics.TotalRequests != uint64(successes+failures+rejections) {
+		t.Errorf("TotalRequests should include rejected requests, got %d", metrics.TotalRequests)
+	}
+
+	// A request is let through whenever the random draw is above the probability
+	roll = 0.99
+	called := false
+	throttle.Execute(func() error {
+		called = true
+		return nil
+	})
+	if !called {
+		t.Error("Request should pass when the draw exceeds the rejection probability")
+	}
+
+	// Once the failures leave the window, traffic flows again
+	clk.Advance(11 * time.Second)
+	roll = 0
+	if p := throttle.RejectionProbability(); p != 0 {
+		t.Errorf("Rejection probability should be 0 after the window expires, got %v", p)
+	}
+	if err := throttle.Execute(func() error { return nil }); err != nil {
+		t.Errorf("Request should succeed after the window expires, got %v", err)
+	}
+}
+
+func TestAdaptiveThrottleIgnoredErrors(t *testing.T) {
+	ignoredErr := errors.New("not found")
+	throttle := NewAdaptiveThrottle(ThrottleSettings{
+		Name:          "TestThrottle",
+		IgnoredErrors: []error{ignoredErr},
+		Rand:          func() float64 { return 0 },
+	})
+
+	// Ignored errors mean the dependency handled the request, so they count as accepts
+	for i := 0; i < 20; i++ {
+		if err := throttle.Execute(func() error { return ignoredErr }); err != ignoredErr {
+			t.Fatalf("Should return the ignored error, got %v", err)
+		}
+	}
+
+	metrics := throttle.GetMetrics()
+	if metrics.TotalRequests != 20 || metrics.TotalFailures != 0 {
+		t.Errorf("Ignored errors should be accepted, got %d requests and %d failures", metrics.TotalRequests, metrics.TotalFailures)
+	}
+	if metrics.RejectionProbability != 0 {
+		t.Errorf("Rejection probability should be 0, got %v", metrics.RejectionProbability)
+	}
+}
+
+func TestThrottleSettingsValidate(t *testing.T) {
+	if err := DefaultThrottleSettings().Validate(); err != nil {
+		t.Errorf("Default settings should be valid, got %v", err)
+	}
+
+	settings := ThrottleSettings{K: 0.5}
+	if err := settings.Validate(); !errors.Is(err, ErrInvalidSettings) {
+		t.Errorf("Invalid settings should wrap ErrInvalidSettings, got %v", err)
+	}
+
+	// The constructor raises K to the smallest valid value, which never
+	// throttles a healthy backend
+	throttle := NewAdaptiveThrottle(settings)
+	for i := 0; i < 10; i++ {
+		throttle.Execute(func() error { return nil })
+	}
+	if p := throttle.RejectionProbability(); p != 0 {
+		t.Errorf("Healthy backend should not be throttled with K below 1, got %v", p)
+	}
+}